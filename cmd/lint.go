@@ -0,0 +1,64 @@
+/*
+Copyright © 2022 Eric F. Wolcott <efwolcott@gmail.com>
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"rtbl/tables"
+
+	"github.com/spf13/cobra"
+)
+
+// lintCmd represents the lint command
+var lintCmd = &cobra.Command{
+	Use:   "lint [table...]",
+	Short: "check tables for problems without rolling them",
+	Long: `Checks each table for range gaps and overlaps, entries beyond the
+die size, references to missing groups or tables, undeclared variables,
+unknown builtins, unbalanced [] {} %, duplicate and unreferenced groups.
+
+With no arguments every table under the root is checked.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		err := loadTables(cmd)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		names := args
+		if len(names) == 0 {
+			names = tables.TableRegistry.Names()
+		}
+
+		problems := 0
+		for _, name := range names {
+			parsedTable, err := tables.Parse(name)
+			if diags, ok := err.(tables.Diagnostics); ok {
+				fmt.Println(diags)
+				problems += len(diags)
+				continue
+			} else if err != nil {
+				fmt.Println(name, ":", err)
+				problems++
+				continue
+			}
+			for _, d := range parsedTable.Diagnostics {
+				fmt.Println(d)
+				problems++
+			}
+			for _, issue := range tables.Lint(parsedTable) {
+				fmt.Println(issue)
+				problems++
+			}
+		}
+		if problems > 0 {
+			fmt.Printf("%d problems found\n", problems)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(lintCmd)
+}
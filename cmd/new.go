@@ -4,8 +4,12 @@ Copyright © 2022 Eric F. Wolcott <efwolcott@gmail.com>
 package cmd
 
 import (
+	"bufio"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"rtbl/rng"
 	"rtbl/tables"
 	"strconv"
 	"strings"
@@ -25,57 +29,47 @@ type TableCall struct {
 }
 
 func parseCall(s string) (TableCall, error) {
-	// [Table.Group(a1,a2)]:n
+	// [Category.Table.Group(a1,a2)]:n
 	var tableCall TableCall
 
-	// Get Table, text within brackets, left of the dot(.) (required)
-	// if no brackets, then entire string is parsed
-	i := strings.Index(s, "[")
-	e := strings.Index(s, "]")
-	if i != -1 && e != -1 {
-		if e <= i {
-			return TableCall{}, fmt.Errorf("Mismatched brackets in group call")
-		} else {
-			if i == -1 {
-				return TableCall{}, fmt.Errorf("No brackets in group call")
-			} else {
-				// lets get rid of the brackets []
-				s = strings.Replace(s[i:], "]", "", 1)
-			}
+	// get repeat Count, number after colon(:) (optional)
+	// 0 when not given, the --count flag is used
+	i := strings.LastIndex(s, ":")
+	if i != -1 {
+		n, err := strconv.ParseInt(s[i+1:], 10, 32)
+		if err != nil {
+			return TableCall{}, fmt.Errorf("Bad repeat count in group call: %s", s[i+1:])
 		}
+		tableCall.repeat = int(n)
+		s = s[:i]
 	}
 
-	// Get Starting Group, text within brackets right of the dot(.) (optional)
-	w := strings.Split(s, ".")
-	tableCall.table = w[0]
-	tableCall.group = "Start"
-	if len(w) == 2 {
-		tableCall.group = w[1]
-		// Get Arguments, text within parens (optional)
-		i = strings.Index(w[1], "(")
-		if i != -1 {
-			e := strings.Index(w[1], ")")
-			if e <= i {
-				return TableCall{}, fmt.Errorf("Mismatched braces in group call")
-			}
-			tableCall.args = strings.Split(w[1][i+1:e], ",")
-			tableCall.group = w[1][:i] // group name stops at open paren [(]
+	// the brackets are optional, if there are none
+	// the entire string is parsed
+	i = strings.Index(s, "[")
+	e := strings.Index(s, "]")
+	if i != -1 || e != -1 {
+		if i == -1 || e <= i {
+			return TableCall{}, fmt.Errorf("Mismatched brackets in group call")
 		}
+		s = s[i+1 : e]
 	}
 
-	// get repeat Count, number after colon(:) (optional)
-	i = strings.LastIndex(s, ":")
+	// Get Arguments, text within parens (optional)
+	i = strings.Index(s, "(")
 	if i != -1 {
-		n, err := strconv.ParseInt(s[i+1:], 10, 32)
-		if err != nil {
-			fmt.Println(err)
+		e := strings.Index(s, ")")
+		if e <= i {
+			return TableCall{}, fmt.Errorf("Mismatched braces in group call")
 		}
-		tableCall.repeat = int(n)
-		s = s[:i]
-	} else {
-		tableCall.repeat = 1
+		tableCall.args = strings.Split(s[i+1:e], ",")
+		s = s[:i] // group name stops at open paren [(]
 	}
 
+	// the table, and group, are split apart by the TableRegistry
+	// as categories also use dots, e.g. Names.Greek.Start
+	tableCall.table = s
+	tableCall.group = "Start"
 	return tableCall, nil
 }
 
@@ -83,102 +77,238 @@ func parseCall(s string) (TableCall, error) {
 var newCmd = &cobra.Command{
 	Use:   "new",
 	Short: "generate a new result from a table",
-	Long:  "",
+	Long: `Examples:
+	$ rtbl new Names.Greek
+	$ rtbl new "Monster(Swamp,Level=3)"
+
+	Put a colon and a count after a table for more than one result,
+	or use --count for every table
+
+	$ rtbl new Tavern:20 --numbered
+	$ rtbl new Encounter --count 50 --unique --separator ", "`,
 	Run: func(cmd *cobra.Command, args []string) {
 
-		env_root := os.Getenv("RTBL_ROOT")
-		root, err := cmd.Flags().GetString("root")
+		err := loadTables(cmd)
 		if err != nil {
 			fmt.Println(err)
 			return
 		}
-		if len(root) == 0 {
-			root = env_root
+		seed, err := getSeed(cmd)
+		if err != nil {
+			fmt.Println(err)
+			return
 		}
-
-		// Load all tables to table registry
-		var rootpath string
-		if root != "" {
-			// humans might enter the path with a wildcard that expands to
-			// contain the Tables sub-dir
-			if !strings.HasSuffix(root, "/Tables") && !strings.HasSuffix(root, "/Tables/") {
-				rootpath = root + "/Tables/"
-			} else {
-				rootpath = root
-			}
-		} else {
-			rootpath = "./Tables"
+		traceFmt, err := cmd.Flags().GetString("trace")
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		showParams, err := cmd.Flags().GetBool("params")
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		share, err := cmd.Flags().GetBool("share")
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		// InputList asks on the terminal, piped in it takes the default
+		var prompter tables.Prompter
+		if term.IsTerminal(0) {
+			prompter = &linePrompter{in: bufio.NewReader(os.Stdin), out: os.Stderr}
+		}
+		newSession := func() *tables.Session {
+			s := tables.NewSession()
+			s.SetPrompter(prompter)
+			return s
 		}
-		err = tables.LoadAllTables(rootpath)
-		//paths, err := tables.FindTables(rootpath)
-		//tableList := tables.NewTableList(paths)
+		// every table is rolled in its own session, so variables
+		// and used entries start fresh, unless asked to share one
+		session := newSession()
+		count, err := cmd.Flags().GetInt("count")
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		unique, err := cmd.Flags().GetBool("unique")
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		tries, err := cmd.Flags().GetInt("tries")
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		numbered, err := cmd.Flags().GetBool("numbered")
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		separator, err := cmd.Flags().GetString("separator")
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		xport, err := cmd.Flags().GetString("export")
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		// results are written as they are made, large
+		// batches are never held in memory
+		out := bufio.NewWriter(os.Stdout)
+		defer out.Flush()
+
 		tablenames := args
 		for _, tn := range tablenames {
 
 			tc, err := parseCall(tn)
+			if err != nil {
+				fmt.Println(tn, ":", err)
+				return
+			}
 
-			parsedTable, err := tables.Parse(tc.table)
+			parsedTable, group, err := tables.Resolve(tc.table)
 			if err != nil {
 				fmt.Println(tn, ":", err)
 				return
 			}
-			// Roll on Table
-			html := parsedTable.Roll(tc.group)
-			// Handle OutputHeader and OutputFooter directive
-			if len(parsedTable.Header) > 0 {
-				html = parsedTable.Header + html
+			tc.group = group
+			if showParams {
+				for _, p := range parsedTable.Params {
+					fmt.Fprintln(out, p)
+				}
+				continue
 			}
-			if len(parsedTable.Footer) > 0 {
-				html = html + parsedTable.Footer
+			if tc.repeat == 0 {
+				tc.repeat = count
 			}
-			xport, err := cmd.Flags().GetString("export")
-			switch xport {
-			case "html":
-				fmt.Println(html)
-			case "text":
-				colWidth := 70
-				widthOpt, err := cmd.Flags().GetInt("width")
-				if widthOpt > 0 {
-					colWidth = widthOpt
+
+			// only needed for --unique, the results already written
+			seen := make(map[string]bool)
+			made := 0
+			for try := 0; made < tc.repeat; try++ {
+				if unique && try >= tc.repeat*tries {
+					fmt.Fprintf(os.Stderr, "%s: gave up after %d tries, only %d unique results\n",
+						tn, try, made)
+					break
+				}
+				if !share {
+					session = newSession()
+				}
+				rolled := session.Table(parsedTable)
+				// arguments set the table parameters, named or in order
+				err = rolled.BindArgs(tc.args)
+				if err != nil {
+					fmt.Println(tn, ":", err)
+					return
+				}
+				// Roll on Table
+				// each result gets its own seed, drawn from the
+				// seed of the one before, so a whole run can be
+				// replayed with --seed, or one result with its seed
+				rolledSeed := seed
+				rng.Seed(rolledSeed)
+				var html string
+				var trace *tables.TraceNode
+				if traceFmt != "" {
+					html, trace = rolled.RollTrace(tc.group)
 				} else {
-					width, _, err := term.GetSize(0)
-					if err != nil {
-						colWidth = 72
-					} else {
-						// never make output too small]
-						if colWidth > 23 {
-							colWidth = int(float64(width) * .75)
-						} else {
-							colWidth = width
-						}
+					html = rolled.Roll(tc.group)
+				}
+				seed = rng.Int63()
+				if unique {
+					if seen[html] {
+						continue
 					}
+					seen[html] = true
 				}
-
-				pto := html2text.NewPrettyTablesOptions()
-				pto.ColWidth = colWidth
-				text, err := html2text.FromString(html,
-					html2text.Options{
-						PrettyTables:        true,
-						PrettyTablesOptions: pto,
-					})
-				if err != nil {
-					panic(err)
+				// Handle OutputHeader and OutputFooter directive
+				if len(rolled.Header) > 0 {
+					html = rolled.Header + html
+				}
+				if len(rolled.Footer) > 0 {
+					html = html + rolled.Footer
 				}
-				fmt.Println(text)
-			case "md":
-				converter := md.NewConverter("", true, nil)
-				markdown, err := converter.ConvertString(html)
+				result, err := exportResult(cmd, xport, html)
 				if err != nil {
-					panic(err)
+					fmt.Println(err)
+					return
 				}
-				fmt.Println(markdown)
-			default:
-				fmt.Printf("Export format is unsupported; %s\n", xport)
+				if made > 0 {
+					fmt.Fprint(out, separator)
+					out.Flush()
+				}
+				fmt.Fprintf(os.Stderr, "seed: %d\n", rolledSeed)
+				made++
+				if numbered {
+					fmt.Fprintf(out, "%d. ", made)
+				}
+				fmt.Fprint(out, result)
+				printTrace(out, traceFmt, trace)
+				out.Flush()
 			}
+			fmt.Fprintln(out)
 		}
 	},
 }
 
+// convert the html of a result to the export format
+func exportResult(cmd *cobra.Command, xport, html string) (string, error) {
+	switch xport {
+	case "html":
+		return html, nil
+	case "text":
+		colWidth := 70
+		widthOpt, _ := cmd.Flags().GetInt("width")
+		if widthOpt > 0 {
+			colWidth = widthOpt
+		} else {
+			width, _, err := term.GetSize(0)
+			if err != nil {
+				colWidth = 72
+			} else {
+				// never make output too small]
+				if colWidth > 23 {
+					colWidth = int(float64(width) * .75)
+				} else {
+					colWidth = width
+				}
+			}
+		}
+
+		pto := html2text.NewPrettyTablesOptions()
+		pto.ColWidth = colWidth
+		return html2text.FromString(html,
+			html2text.Options{
+				PrettyTables:        true,
+				PrettyTablesOptions: pto,
+			})
+	case "md":
+		converter := md.NewConverter("", true, nil)
+		return converter.ConvertString(html)
+	}
+	return "", fmt.Errorf("Export format is unsupported; %s", xport)
+}
+
+func printTrace(out io.Writer, traceFmt string, trace *tables.TraceNode) {
+	switch traceFmt {
+	case "":
+	case "json":
+		js, err := json.MarshalIndent(trace, "", "  ")
+		if err != nil {
+			fmt.Fprintln(out, "Internal Error:", err)
+		}
+		fmt.Fprintf(out, "\n%s\n", js)
+	case "tree":
+		fmt.Fprintf(out, "\n%s", trace)
+	default:
+		fmt.Fprintf(out, "\nTrace format is unsupported; %s\n", traceFmt)
+	}
+}
+
 func init() {
 	rootCmd.AddCommand(newCmd)
 
@@ -192,4 +322,14 @@ func init() {
 	// is called directly, e.g.:
 	newCmd.Flags().StringP("export", "x", "text", "output format (text,html,md)")
 	newCmd.Flags().IntP("width", "w", 0, "width of text output")
+	newCmd.Flags().String("trace", "", "show how the result was rolled (tree,json)")
+	newCmd.Flags().Lookup("trace").NoOptDefVal = "tree"
+	newCmd.Flags().Int64("seed", 0, "seed for random rolls, the seed used is printed with each result")
+	newCmd.Flags().IntP("count", "n", 1, "number of results for each table, Table:n sets it for one table")
+	newCmd.Flags().StringP("separator", "s", "\n", "written between the results of a table")
+	newCmd.Flags().Bool("numbered", false, "number the results of each table")
+	newCmd.Flags().Bool("unique", false, "reroll until every result of a table is different")
+	newCmd.Flags().Int("tries", 10, "with --unique, give up after count times this many rolls")
+	newCmd.Flags().Bool("share", false, "roll every table in one session, variables and used entries carry over")
+	newCmd.Flags().Bool("params", false, "list the parameters of the tables instead of rolling, pass arguments as Table(a1,Name=a2)")
 }
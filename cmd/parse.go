@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 	"rtbl/tables"
 	"strings"
 
@@ -25,6 +26,15 @@ var parseCmd = &cobra.Command{
 			fmt.Println(err)
 			return
 		}
+		format, err := cmd.Flags().GetString("format")
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if format != "text" && format != "json" {
+			fmt.Printf("Diagnostic format is unsupported; %s\n", format)
+			return
+		}
 		env_root := os.Getenv("RTBL_ROOT")
 		root, err := cmd.Flags().GetString("root")
 		if err != nil {
@@ -34,43 +44,33 @@ var parseCmd = &cobra.Command{
 		if len(root) == 0 {
 			root = env_root
 		}
-		//if len(root) > 0 && root[0] != '/' {
-		//		root = env_root + "/" + root
-		//}
+		var paths []string
 		if len(args) > 0 {
 			for _, name := range args {
-				path := root + "/" + name
+				path := filepath.Join(root, name)
 				if !strings.HasSuffix(path, ".tab") {
 					path = path + ".tab"
 				}
-				parsedTable, err := tables.Parse(path)
-				if err != nil {
-					fmt.Println(path, ":", err)
-				} else {
-					fmt.Println(path, " No errors")
-					if xport {
-						// Marshalling the structure
-						// For now ignoring error
-						// but you should handle
-						// the error in above function
-						_, err := json.MarshalIndent(parsedTable, "", "  ")
-						if err != nil {
-							fmt.Println("Internal Error:", err)
-						}
-						// typecasting byte array to string
-						//fmt.Println(string(jsonF))
-						fmt.Println(parsedTable)
-					}
-				}
+				paths = append(paths, path)
 			}
 		} else {
-			parsedTable, err := tables.Parse(root)
-			if err != nil {
-				fmt.Println(err)
-				return
+			paths = append(paths, root)
+		}
+
+		// every error and warning of every table
+		all := tables.Diagnostics{}
+		for _, path := range paths {
+			parsedTable, diags := tables.ParseFile(path)
+			all = append(all, diags...)
+			if format == "text" {
+				for _, d := range diags {
+					fmt.Println(d)
+				}
+				if !diags.HasErrors() {
+					fmt.Println(path, " No errors")
+				}
 			}
-			fmt.Println(root, " No errors")
-			if xport {
+			if xport && parsedTable != nil {
 				// Marshalling the structure
 				// For now ignoring error
 				// but you should handle
@@ -79,12 +79,19 @@ var parseCmd = &cobra.Command{
 				if err != nil {
 					fmt.Println("Internal Error:", err)
 				}
-
 				// typecasting byte array to string
 				//fmt.Println(string(jsonF))
 				fmt.Println(parsedTable)
 			}
 		}
+		if format == "json" {
+			js, err := json.MarshalIndent(all, "", "  ")
+			if err != nil {
+				fmt.Println("Internal Error:", err)
+				return
+			}
+			fmt.Println(string(js))
+		}
 	},
 }
 
@@ -100,4 +107,5 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	parseCmd.Flags().BoolP("export", "x", false, "print table as json")
+	parseCmd.Flags().String("format", "text", "format of errors and warnings (text,json)")
 }
@@ -0,0 +1,46 @@
+/*
+Copyright © 2022 Eric F. Wolcott <efwolcott@gmail.com>
+*/
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"rtbl/tables"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// asks for InputList choices, an empty line is the default
+type linePrompter struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+func (lp *linePrompter) Prompt(ctx context.Context, table string, p *tables.Param) (string, error) {
+	fmt.Fprintln(lp.out, p)
+	line, err := lp.in.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// asks for InputList choices on the terminal of the shell
+type termPrompter struct {
+	t *term.Terminal
+}
+
+func (tp *termPrompter) Prompt(ctx context.Context, table string, p *tables.Param) (string, error) {
+	fmt.Fprintln(tp.t, p)
+	tp.t.SetPrompt("? ")
+	defer tp.t.SetPrompt("rtbl> ")
+	line, err := tp.t.ReadLine()
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
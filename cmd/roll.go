@@ -6,9 +6,11 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 	"strconv"
 	"strings"
 
+	"rtbl/rng"
 	"rtbl/tables"
 
 	"github.com/spf13/cobra"
@@ -35,6 +37,12 @@ var rollCmd = &cobra.Command{
 	dmg 
 	8 8 4 8 4 6 1 2 5 5 1 4 `,
 	Run: func(cmd *cobra.Command, args []string) {
+		seed, err := getSeed(cmd)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		rng.Seed(seed)
 		t := tables.NewTable("dummy")
 		for j := 0; j < len(args); j++ {
 			// is this a multi roll
@@ -60,6 +68,7 @@ var rollCmd = &cobra.Command{
 
 		}
 		fmt.Println("")
+		fmt.Fprintf(os.Stderr, "seed: %d\n", seed)
 	},
 }
 
@@ -75,4 +84,5 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// rollCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	rollCmd.Flags().Int64("seed", 0, "seed for random rolls, the seed used is printed after the rolls")
 }
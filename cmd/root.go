@@ -5,6 +5,9 @@ package cmd
 
 import (
 	"os"
+	"rtbl/rng"
+	"rtbl/tables"
+	"strings"
 
 	"github.com/spf13/cobra"
 )
@@ -28,6 +31,42 @@ func Execute() {
 	}
 }
 
+// loadTables finds the Tables directory from the --root flag or
+// RTBL_ROOT and loads every table in it to the table registry
+func loadTables(cmd *cobra.Command) error {
+	env_root := os.Getenv("RTBL_ROOT")
+	root, err := cmd.Flags().GetString("root")
+	if err != nil {
+		return err
+	}
+	if len(root) == 0 {
+		root = env_root
+	}
+
+	var rootpath string
+	if root != "" {
+		// humans might enter the path with a wildcard that expands to
+		// contain the Tables sub-dir
+		if !strings.HasSuffix(root, "/Tables") && !strings.HasSuffix(root, "/Tables/") {
+			rootpath = root + "/Tables/"
+		} else {
+			rootpath = root
+		}
+	} else {
+		rootpath = "./Tables"
+	}
+	return tables.LoadAllTables(rootpath)
+}
+
+// getSeed returns the --seed flag when given, otherwise a new
+// seed so that every run can still be replayed
+func getSeed(cmd *cobra.Command) (int64, error) {
+	if !cmd.Flags().Changed("seed") {
+		return rng.NewSeed(), nil
+	}
+	return cmd.Flags().GetInt64("seed")
+}
+
 func init() {
 	env_root := os.Getenv("RTBL_ROOT")
 	help := "root directory directory to start table lookup. \n"
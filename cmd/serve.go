@@ -0,0 +1,57 @@
+/*
+Copyright © 2022 Eric F. Wolcott <efwolcott@gmail.com>
+*/
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"rtbl/server"
+
+	"github.com/spf13/cobra"
+)
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "roll on tables from a browser, or a HTTP JSON API",
+	Long: `Examples:
+	$ rtbl serve --addr localhost:8080
+
+	Open http://localhost:8080 in a browser to pick tables and roll,
+	or call the API
+
+	$ curl 'localhost:8080/api/generate?table=Names.Greek&count=5'
+	$ curl -d '{"table":"Monster","args":["Swamp"],"seed":7}' localhost:8080/api/generate
+
+	GET  /api/categories        tables by category
+	GET  /api/tables            full name of every table
+	GET  /api/tables/{name}     groups and parameters of a table
+	GET  /api/generate          table, arg, var=Name=value, seed, count, share, trace
+	POST /api/generate          the same as JSON
+	GET  /api/dice?expr=3d6+1   roll dice`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		err := loadTables(cmd)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		addr, err := cmd.Flags().GetString("addr")
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("serving tables on http://%s\n", addr)
+		err = http.ListenAndServe(addr, server.NewHandler())
+		if err != nil {
+			fmt.Println(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+
+	serveCmd.Flags().String("addr", "localhost:8080", "address to listen on")
+}
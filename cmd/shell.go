@@ -0,0 +1,352 @@
+/*
+Copyright © 2022 Eric F. Wolcott <efwolcott@gmail.com>
+*/
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"rtbl/rng"
+	"rtbl/tables"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+const SHELL_HELP = `Table calls, dice and commands, one per line
+  Sample.Creature       roll on a table, or a group of it
+  [Mission]:3           roll three times
+  Monster(Swamp,3)      pass arguments to the table parameters
+  3d6+1                 roll dice
+  set Var=value         set a variable of the current table
+  set                   list the variables of the current table
+  groups [Table]        list the groups of a table
+  reset Group           let a use once group use every entry again
+  reset                 start a new session, all variables are reset
+  reroll                roll the last table call again
+  help                  show this help
+  quit                  leave the shell, so does Ctrl-D`
+
+// NdM, a dice expression rather than a table call
+var lexShellDice = regexp.MustCompile(`^[0-9]*d[0-9]`)
+
+// the state of an interactive shell, tables are loaded once and
+// every roll shares a session so variables carry over
+type shell struct {
+	cmd     *cobra.Command
+	out     io.Writer
+	xport   string
+	session *tables.Session
+	prompt  tables.Prompter // asked by InputList, nil for the default
+	current *tables.Table   // the last table rolled, used by set and reset
+	last    string          // the last table call, used by reroll
+}
+
+// shellCmd represents the shell command
+var shellCmd = &cobra.Command{
+	Use:   "shell",
+	Short: "roll on tables interactively",
+	Long:  SHELL_HELP,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		err := loadTables(cmd)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		seed, err := getSeed(cmd)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		rng.Seed(seed)
+		fmt.Fprintf(os.Stderr, "seed: %d\n", seed)
+		xport, err := cmd.Flags().GetString("export")
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		histfile, err := cmd.Flags().GetString("history")
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		sh := &shell{
+			cmd:     cmd,
+			out:     os.Stdout,
+			xport:   xport,
+			session: tables.NewSession(),
+		}
+		// without a terminal, e.g. commands piped in, there is
+		// no line editing or history
+		if !term.IsTerminal(0) {
+			scanner := bufio.NewScanner(os.Stdin)
+			for scanner.Scan() {
+				if !sh.exec(scanner.Text()) {
+					return
+				}
+			}
+			return
+		}
+
+		state, err := term.MakeRaw(0)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer term.Restore(0, state)
+		in := &historyReader{r: os.Stdin, w: os.Stdout}
+		terminal := term.NewTerminal(in, "rtbl> ")
+		if width, height, err := term.GetSize(0); err == nil && width > 0 {
+			terminal.SetSize(width, height)
+		}
+		in.load(terminal, histfile)
+		sh.out = terminal
+		sh.prompt = &termPrompter{t: terminal}
+		sh.session.SetPrompter(sh.prompt)
+
+		for {
+			line, err := terminal.ReadLine()
+			if err != nil {
+				if err != io.EOF {
+					fmt.Fprintln(terminal, err)
+				}
+				return
+			}
+			if strings.TrimSpace(line) != "" {
+				appendHistory(histfile, line)
+			}
+			if !sh.exec(line) {
+				return
+			}
+		}
+	},
+}
+
+// run a single line, false when the shell should stop
+func (sh *shell) exec(line string) bool {
+	line = strings.TrimSpace(line)
+	var word, rest string
+	if fields := strings.Fields(line); len(fields) > 0 {
+		word = strings.ToLower(fields[0])
+		rest = strings.TrimSpace(line[len(fields[0]):])
+	}
+	switch word {
+	case "":
+	case "quit", "exit":
+		return false
+	case "help", "?":
+		fmt.Fprintln(sh.out, SHELL_HELP)
+	case "set":
+		sh.set(rest)
+	case "groups":
+		sh.groups(rest)
+	case "reset":
+		sh.reset(rest)
+	case "reroll":
+		if sh.last == "" {
+			fmt.Fprintln(sh.out, "nothing to reroll")
+			break
+		}
+		sh.roll(sh.last)
+	default:
+		if lexShellDice.MatchString(line) {
+			res, err := tables.BuiltinCall(tables.NewTable("dice"), "Dice", line)
+			if err == nil {
+				fmt.Fprintln(sh.out, res)
+				break
+			}
+		}
+		// only a call that found its table is worth a reroll
+		if sh.roll(line) {
+			sh.last = line
+		}
+	}
+	return true
+}
+
+// roll on a table call, e.g. [Mission]:3 or Monster(Swamp),
+// false when the call or its table is unknown
+func (sh *shell) roll(call string) bool {
+	tc, err := parseCall(call)
+	if err != nil {
+		fmt.Fprintln(sh.out, call, ":", err)
+		return false
+	}
+	parsedTable, group, err := tables.Resolve(tc.table)
+	if err != nil {
+		fmt.Fprintln(sh.out, call, ":", err)
+		return false
+	}
+	if tc.repeat == 0 {
+		tc.repeat = 1
+	}
+	rolled := sh.session.Table(parsedTable)
+	sh.current = rolled
+	for j := 0; j < tc.repeat; j++ {
+		// arguments set the table parameters, named or in order
+		if len(tc.args) > 0 {
+			if err := rolled.BindArgs(tc.args); err != nil {
+				fmt.Fprintln(sh.out, call, ":", err)
+				return true
+			}
+		}
+		html := rolled.Header + rolled.Roll(group) + rolled.Footer
+		result, err := exportResult(sh.cmd, sh.xport, html)
+		if err != nil {
+			fmt.Fprintln(sh.out, err)
+			return true
+		}
+		fmt.Fprintln(sh.out, result)
+	}
+	return true
+}
+
+// set Var=value, or list the variables
+func (sh *shell) set(s string) {
+	if sh.current == nil {
+		fmt.Fprintln(sh.out, "no table has been rolled yet")
+		return
+	}
+	if s == "" {
+		var names []string
+		for name := range sh.current.Variables {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(sh.out, "%s=%s\n", name, sh.current.Variables[name])
+		}
+		return
+	}
+	idx := strings.Index(s, "=")
+	if idx < 1 {
+		fmt.Fprintln(sh.out, "usage: set Var=value")
+		return
+	}
+	sh.current.AddVariable(strings.TrimSpace(s[:idx]), strings.TrimSpace(s[idx+1:]))
+}
+
+// list the groups of a table, or the current one
+func (sh *shell) groups(name string) {
+	t := sh.current
+	if name != "" {
+		parsedTable, err := tables.Parse(name)
+		if err != nil {
+			fmt.Fprintln(sh.out, name, ":", err)
+			return
+		}
+		t = parsedTable
+	}
+	if t == nil {
+		fmt.Fprintln(sh.out, "usage: groups Table")
+		return
+	}
+	for _, g := range t.GroupNames() {
+		fmt.Fprintln(sh.out, g)
+	}
+}
+
+// reset a use once group of the current table, or the whole session
+func (sh *shell) reset(group string) {
+	if group == "" {
+		sh.session = tables.NewSession()
+		sh.session.SetPrompter(sh.prompt)
+		sh.current = nil
+		return
+	}
+	if sh.current == nil {
+		fmt.Fprintln(sh.out, "no table has been rolled yet")
+		return
+	}
+	g, err := sh.current.GetGroup(group)
+	if err != nil {
+		fmt.Fprintln(sh.out, err)
+		return
+	}
+	g.Reset()
+}
+
+// the terminal only adds to its history lines it has read, so
+// saved history is read through it first, with the echo thrown away
+type historyReader struct {
+	r       io.Reader
+	w       io.Writer
+	preload bytes.Buffer
+	quiet   bool // true while the saved history is read
+}
+
+func (h *historyReader) Read(p []byte) (int, error) {
+	if h.preload.Len() > 0 {
+		return h.preload.Read(p)
+	}
+	return h.r.Read(p)
+}
+
+func (h *historyReader) Write(p []byte) (int, error) {
+	if h.quiet {
+		return len(p), nil
+	}
+	return h.w.Write(p)
+}
+
+// read the saved history into the terminal, the newest 100 lines
+// are kept by the terminal
+func (h *historyReader) load(terminal *term.Terminal, histfile string) {
+	content, err := os.ReadFile(histfile)
+	if err != nil {
+		return
+	}
+	var lines []string
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) > 100 {
+		lines = lines[len(lines)-100:]
+	}
+	for _, line := range lines {
+		h.preload.WriteString(line + "\r")
+	}
+	h.quiet = true
+	defer func() { h.quiet = false }()
+	for range lines {
+		if _, err := terminal.ReadLine(); err != nil {
+			break
+		}
+	}
+}
+
+func appendHistory(histfile, line string) {
+	if histfile == "" {
+		return
+	}
+	f, err := os.OpenFile(histfile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, line)
+}
+
+func init() {
+	rootCmd.AddCommand(shellCmd)
+
+	histfile := ""
+	if home, err := os.UserHomeDir(); err == nil {
+		histfile = filepath.Join(home, ".rtbl_history")
+	}
+	shellCmd.Flags().StringP("export", "x", "text", "output format (text,html,md)")
+	shellCmd.Flags().IntP("width", "w", 0, "width of text output")
+	shellCmd.Flags().Int64("seed", 0, "seed for random rolls")
+	shellCmd.Flags().String("history", histfile, "file the command history is kept in, empty for none")
+}
@@ -2,34 +2,45 @@ package datasets
 
 import (
 	"fmt"
-	"math/rand"
 	"os"
+	"rtbl/rng"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 /*
- * Dataset Registry maps DS name to actual data
+ * Registry maps DS name to actual data, each Session of the
+ * tables package has its own, so datasets made by one generation
+ * are never seen by another
  */
-type dsRegistry map[string]*dataset
+type Registry struct {
+	mu   sync.RWMutex // guards sets
+	sets map[string]*dataset
+}
 
-var registry dsRegistry = make(map[string]*dataset)
+func NewRegistry() *Registry {
+	return &Registry{sets: make(map[string]*dataset)}
+}
 
-func findDS(name string) (*dataset, error) {
-	ds, exists := registry[name]
+func (r *Registry) findDS(name string) (*dataset, error) {
+	r.mu.RLock()
+	ds, exists := r.sets[strings.ToLower(strings.TrimSpace(name))]
+	r.mu.RUnlock()
 	if !exists {
 		return nil, fmt.Errorf("%s is not an current Dataset", name)
 	}
 	return ds, nil
 }
 
-func addDS(name string, ds *dataset) error {
-	name = strings.ToLower(name)
-	ds, _ = findDS(name)
-	if ds != nil {
-		return fmt.Errorf("%s is an existing DS", ds.name)
+func (r *Registry) addDS(name string, ds *dataset) error {
+	name = strings.ToLower(strings.TrimSpace(name))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if old, exists := r.sets[name]; exists {
+		return fmt.Errorf("%s is an existing DS", old.name)
 	}
-	registry[name] = ds
+	r.sets[name] = ds
 	return nil
 }
 
@@ -41,6 +52,7 @@ type row []string
 //   a row of default value
 //   and an array of rows/values
 type dataset struct {
+	mu       sync.Mutex // guards rows
 	name     string
 	headers  row
 	defaults row
@@ -58,8 +70,12 @@ func (d *dataset) NewRow() row {
 	return append([]string{}, d.defaults...)
 }
 
-func (d *dataset) AddRow(nrow row) {
+// AddRow returns the index of the added row
+func (d *dataset) AddRow(nrow row) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	d.rows = append(d.rows, nrow)
+	return len(d.rows) - 1
 }
 
 func (d *dataset) IsColumn(c string) int {
@@ -71,17 +87,17 @@ func (d *dataset) IsColumn(c string) int {
 	return -1
 }
 
-func DSAdd(s string) (string, error) {
+func (r *Registry) DSAdd(s string) (string, error) {
 	//DSAdd~VarName,Field1,Value1,Field2,Value2,...
 	fields := strings.Split(s, ",")
-	ds, err := findDS(fields[0])
+	ds, err := r.findDS(fields[0])
 	if err != nil {
 		return "", fmt.Errorf("%s is not a dataset name", fields[0])
 	}
 	newrow := ds.NewRow() // get new row to defaults
 	// go thru each field, check to see if it exists
 	// set values that are provided
-	for j := 1; j < len(fields); j = j + 2 {
+	for j := 1; j+1 < len(fields); j = j + 2 {
 		fld := fields[j]
 		val := fields[j+1]
 		// find if this is a column, by name
@@ -93,20 +109,22 @@ func DSAdd(s string) (string, error) {
 		newrow[idx] = val
 	}
 	// values set, so lets add the new row to the dataset
-	ds.AddRow(newrow)
-	// return index of added row
-	return strconv.Itoa(len(ds.rows) - 1), nil
+	// and return index of added row
+	return strconv.Itoa(ds.AddRow(newrow)), nil
 }
 
-func DSAddNR(s string) (string, error) {
-	_, err := DSAdd(s) // throw away index of row
+func (r *Registry) DSAddNR(s string) (string, error) {
+	_, err := r.DSAdd(s) // throw away index of row
 	return "", err
 }
 
-func DSCalc(s string) (string, error) {
+func (r *Registry) DSCalc(s string) (string, error) {
 	//DSCalc~VarName,Operation,Field
 	fields := strings.Split(s, ",")
-	ds, err := findDS(fields[0])
+	if len(fields) != 3 {
+		return "", fmt.Errorf("DSCalc~VarName,Operation,Field: %s", s)
+	}
+	ds, err := r.findDS(fields[0])
 	if err != nil {
 		return "", fmt.Errorf("%s is not a dataset name", fields[0])
 	}
@@ -115,6 +133,8 @@ func DSCalc(s string) (string, error) {
 	if idx == -1 {
 		return "", fmt.Errorf("%s is not a column in dataset %s", fields[2], fields[0])
 	}
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
 	// Sum all the columns values
 	acc := 0.0
 	for j := 0; j < len(ds.rows); j++ {
@@ -131,29 +151,32 @@ func DSCalc(s string) (string, error) {
 	return strconv.FormatFloat(acc, 'f', 3, 64), nil
 }
 
-func DSCount(s string) (string, error) {
+func (r *Registry) DSCount(s string) (string, error) {
 	//DSCount~VarName
-	ds, err := findDS(s)
+	ds, err := r.findDS(s)
 	if err != nil {
 		return "", fmt.Errorf("%s is not a dataset name", s)
 	}
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
 	return strconv.Itoa(len(ds.rows)), nil
 }
 
-func DSCreate(s string) (string, error) {
+func (r *Registry) DSCreate(s string) (string, error) {
 	//DSCreate~VarName,Field1,Default1,Field2,Default2,...Fieldx,Defaultx
 	fields := strings.Split(s, ",")
 	dsname := fields[0]
-	defrow := make(map[string]string)
+	if len(fields)%2 != 1 {
+		return "", fmt.Errorf("DSCreate~%s: a field has no default", s)
+	}
 	ds := newDS(dsname)
-	for j := 0; j < len(fields); j = j + 2 {
+	for j := 1; j+1 < len(fields); j = j + 2 {
 		ds.headers = append(ds.headers, fields[j])
-		defrow[fields[j]] = fields[j+1]
+		ds.defaults = append(ds.defaults, fields[j+1])
 	}
-	addDS(dsname, ds)
-	return "", nil
+	return "", r.addDS(dsname, ds)
 }
-func DSFind(s string) (string, error) {
+func (r *Registry) DSFind(s string) (string, error) {
 	//DSFind~VarName,Index,Expr1,Expr2,...
 	/*
 		Starting at the item with index "Index", searches through each item until it finds
@@ -168,12 +191,15 @@ func DSFind(s string) (string, error) {
 	return "", nil
 
 }
-func DSGet(s string) (string, error) {
+func (r *Registry) DSGet(s string) (string, error) {
 	//DSGet~VarName,Index,Field
 	fields := strings.Split(s, ",")
-	ds, err := findDS(s)
+	if len(fields) != 3 {
+		return "", fmt.Errorf("DSGet~VarName,Index,Field: %s", s)
+	}
+	ds, err := r.findDS(fields[0])
 	if err != nil {
-		return "", fmt.Errorf("%s is not a dataset name", s)
+		return "", fmt.Errorf("%s is not a dataset name", fields[0])
 	}
 	irow, err := strconv.Atoi(fields[1])
 	if err != nil {
@@ -183,49 +209,55 @@ func DSGet(s string) (string, error) {
 	if icol == -1 {
 		return "", fmt.Errorf("DSGet~%s is not a valid field", s)
 	}
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if irow < 0 || irow >= len(ds.rows) {
+		return "", fmt.Errorf("DSGet~%s is not a valid index", s)
+	}
 	return ds.rows[irow][icol], nil
 }
 
-func DSRandomize(s string) (string, error) {
-	ds, err := findDS(s)
+func (r *Registry) DSRandomize(src rng.Source, s string) (string, error) {
+	ds, err := r.findDS(s)
 	if err != nil {
-		return "", fmt.Errorf("%s is not a dataset name")
+		return "", fmt.Errorf("%s is not a dataset name", s)
 	}
-
-	rand.Shuffle(len(ds.rows), func(i, j int) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	src.Shuffle(len(ds.rows), func(i, j int) {
 		ds.rows[i], ds.rows[j] = ds.rows[j], ds.rows[i]
 	})
 	return "", nil
 }
-func DSRead(s string) (string, error) {
+func (r *Registry) DSRead(s string) (string, error) {
 	return "", nil
 
 }
-func DSRemove(s string) (string, error) {
+func (r *Registry) DSRemove(s string) (string, error) {
 	//DSRemove~VarName,Index
 	return "", nil
 
 }
-func DSRoll(s string) (string, error) {
+func (r *Registry) DSRoll(s string) (string, error) {
 	//DSRoll~VarName,Field@Mod
 	return "", nil
 
 }
-func DSSet(s string) (string, error) {
+func (r *Registry) DSSet(s string) (string, error) {
 	//DSSet~VarName,Index,Field1,Value1,Field2,Value2,...
 	return "", nil
 
 }
-func DSSort(s string) (string, error) {
+func (r *Registry) DSSort(s string) (string, error) {
 	//DSSort~~VarName,Field1,Direction1,Field2,Direction2,...
 	return "", nil
 
 }
-func DSWrite(s string) (string, error) {
+func (r *Registry) DSWrite(s string) (string, error) {
 	//DSWrite~VarName,Filename
 	args := strings.Split(s, ",")
 	dsname := args[0]
-	ds, err := findDS(dsname)
+	ds, err := r.findDS(dsname)
 	if err != nil {
 		return "", fmt.Errorf("%s is not a dataset name", dsname)
 	}
@@ -237,6 +269,8 @@ func DSWrite(s string) (string, error) {
 	}
 	// remember to close the file
 	defer dsfile.Close()
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
 
 	dsfile.WriteString("# DataSet written by RTBL in RDB Format")
 	dsfile.WriteString("# RDB Format is a Tab Seperate Values with a 2 line header")
@@ -246,8 +280,8 @@ func DSWrite(s string) (string, error) {
 	//  but first calculate width
 	max := make([]int, len(ds.headers))
 	for i := 0; i < len(ds.rows); i++ {
-		row := &ds.rows[i]
-		for j := 0; j < len_row; j++ {
+		row := ds.rows[i]
+		for j := 0; j < len(row); j++ {
 			if len(row[j]) > max[j] {
 				max[j] = len(row[j])
 			}
@@ -265,4 +299,10 @@ func DSWrite(s string) (string, error) {
 	for j := 0; j < len(ds.rows); j++ {
 		dsfile.WriteString(strings.Join(ds.rows[j], "\t"))
 	}
+	return "", nil
+}
+
+// make sure the Data directory that DSWrite/DSRead use exists
+func mkdatadir() error {
+	return os.MkdirAll("Data", 0755)
 }
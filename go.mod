@@ -4,8 +4,6 @@ go 1.18
 
 require (
 	github.com/JohannesKaufmann/html-to-markdown v1.3.5
-	github.com/Knetic/govaluate v3.0.0+incompatible
-	github.com/nboughton/go-roll v0.0.17
 	github.com/spf13/cobra v1.5.0
 	golang.org/x/term v0.0.0-20220919170432-7a66f970e087
 	jaytaylor.com/html2text v0.0.0-20211105163654-bc68cce691ba
@@ -0,0 +1,101 @@
+// Package rng holds the single source of randomness used by rtbl.
+//
+// Every random choice made while generating, group rolls, dice, dataset
+// shuffles and useOnce rerolls, is drawn from this source, so seeding it
+// once makes a whole generation repeatable.
+package rng
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Source is anything that can provide random numbers to rtbl,
+// *rand.Rand satisfies it
+type Source interface {
+	Intn(n int) int
+	Int63() int64
+	Shuffle(n int, swap func(i, j int))
+}
+
+// the package source may be used from many goroutines, a
+// *rand.Rand may not, so every call holds the lock
+type lockedSource struct {
+	mu   sync.Mutex
+	src  Source
+	seed int64
+}
+
+func (l *lockedSource) Intn(n int) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.src.Intn(n)
+}
+
+func (l *lockedSource) Int63() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.src.Int63()
+}
+
+func (l *lockedSource) Shuffle(n int, swap func(i, j int)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.src.Shuffle(n, swap)
+}
+
+var src = &lockedSource{}
+
+func init() {
+	Seed(NewSeed())
+}
+
+// NewSeed returns a seed taken from the clock, used when the
+// user did not supply one
+func NewSeed() int64 {
+	return time.Now().UnixNano()
+}
+
+// Seed replaces the current source with a new one started from s
+func Seed(s int64) {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	src.seed = s
+	src.src = rand.New(rand.NewSource(s))
+}
+
+// CurrentSeed returns the seed the current source was started from
+func CurrentSeed() int64 {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	return src.seed
+}
+
+// Set injects a source, e.g. a fixed sequence in tests
+func Set(s Source) {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	src.src = s
+}
+
+// Get returns the current source, it is safe for use
+// by many goroutines
+func Get() Source {
+	return src
+}
+
+// Intn returns a number in [0,n) from the current source
+func Intn(n int) int {
+	return src.Intn(n)
+}
+
+// Int63 returns a non-negative 63 bit number from the current source
+func Int63() int64 {
+	return src.Int63()
+}
+
+// Shuffle randomizes the order of n elements using the current source
+func Shuffle(n int, swap func(i, j int)) {
+	src.Shuffle(n, swap)
+}
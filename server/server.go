@@ -0,0 +1,313 @@
+// provides the HTTP JSON API used by rtbl serve, so web tools
+// and VTT macros can roll on tables without running rtbl
+package server
+
+/*
+ * The browser UI, in the ui directory, is served from /
+ * All /api responses are JSON, errors are {"error": "message"}
+ *
+ *   GET  /api/categories        tables by category
+ *   GET  /api/tables            full name of every table
+ *   GET  /api/tables/{name}     groups and parameters of a table
+ *   GET  /api/generate?table=Monster&arg=Swamp&var=Gold=10&seed=5&count=3&trace=true
+ *   POST /api/generate          the same as a GenerateRequest in the body
+ *   GET  /api/dice?expr=3d6+1   roll dice
+ */
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/fs"
+	"net/http"
+	"rtbl/rng"
+	"rtbl/tables"
+	"strconv"
+	"strings"
+
+	md "github.com/JohannesKaufmann/html-to-markdown"
+	"jaytaylor.com/html2text"
+)
+
+type GenerateRequest struct {
+	Table     string            `json:"table"`               // Table, Table.Group or Category.Table.Group
+	Args      []string          `json:"args,omitempty"`      // table parameters, in order or Name=value
+	Variables map[string]string `json:"variables,omitempty"` // set before rolling
+	Seed      *int64            `json:"seed,omitempty"`      // a new seed when not given
+	Count     int               `json:"count,omitempty"`     // 1 when not given
+	Share     bool              `json:"share,omitempty"`     // roll every result in one session
+	Trace     bool              `json:"trace,omitempty"`
+}
+
+type Result struct {
+	HTML     string            `json:"html"`
+	Text     string            `json:"text"`
+	Markdown string            `json:"markdown"`
+	Trace    *tables.TraceNode `json:"trace,omitempty"`
+}
+
+type GenerateResponse struct {
+	Table   string   `json:"table"`
+	Group   string   `json:"group"`
+	Seed    int64    `json:"seed"` // replays every result
+	Results []Result `json:"results"`
+}
+
+type ParamInfo struct {
+	Name    string   `json:"name"`
+	Default string   `json:"default"`
+	Prompt  string   `json:"prompt"`
+	Choices []string `json:"choices,omitempty"`
+}
+
+type TableInfo struct {
+	Name   string      `json:"name"`
+	Path   string      `json:"path"`
+	Groups []string    `json:"groups"`
+	Params []ParamInfo `json:"params"`
+}
+
+// the most results one request can ask for
+const MAX_COUNT = 1000
+
+// the text rendering is wrapped to this width
+const TEXT_WIDTH = 72
+
+// the browser UI, a single page using the API
+//
+//go:embed ui
+var ui embed.FS
+
+// requests are served at once, each roll has its own Session
+// and random source, the parsed tables are shared
+type server struct{}
+
+// NewHandler returns the API handler, the TableRegistry
+// must already be loaded, see tables.LoadAllTables
+func NewHandler() http.Handler {
+	s := &server{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/categories", s.categories)
+	mux.HandleFunc("/api/tables", s.tableList)
+	mux.HandleFunc("/api/tables/", s.table)
+	mux.HandleFunc("/api/generate", s.generate)
+	mux.HandleFunc("/api/dice", s.dice)
+	pages, _ := fs.Sub(ui, "ui")
+	mux.Handle("/", http.FileServer(http.FS(pages)))
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func onlyGet(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s is not allowed", r.Method))
+		return false
+	}
+	return true
+}
+
+func (s *server) categories(w http.ResponseWriter, r *http.Request) {
+	if !onlyGet(w, r) {
+		return
+	}
+	writeJSON(w, http.StatusOK, tables.NewTablesByCategory(tables.TableRegistry.Paths()))
+}
+
+func (s *server) tableList(w http.ResponseWriter, r *http.Request) {
+	if !onlyGet(w, r) {
+		return
+	}
+	writeJSON(w, http.StatusOK, tables.TableRegistry.Names())
+}
+
+func (s *server) table(w http.ResponseWriter, r *http.Request) {
+	if !onlyGet(w, r) {
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/api/tables/")
+	t, err := tables.Parse(name)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	info := TableInfo{
+		Name:   t.Name,
+		Path:   t.Path,
+		Groups: t.GroupNames(),
+		Params: []ParamInfo{},
+	}
+	for _, p := range t.Params {
+		info.Params = append(info.Params, ParamInfo{
+			Name:    p.Name,
+			Default: p.Default,
+			Prompt:  p.Prompt,
+			Choices: p.Choices,
+		})
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// a GenerateRequest from the query string of a GET
+func queryRequest(r *http.Request) (GenerateRequest, error) {
+	q := r.URL.Query()
+	req := GenerateRequest{
+		Table: q.Get("table"),
+		Args:  q["arg"],
+	}
+	for _, v := range q["var"] {
+		idx := strings.Index(v, "=")
+		if idx < 1 {
+			return req, fmt.Errorf("var must be Name=value, not %s", v)
+		}
+		if req.Variables == nil {
+			req.Variables = make(map[string]string)
+		}
+		req.Variables[v[:idx]] = v[idx+1:]
+	}
+	if v := q.Get("seed"); v != "" {
+		seed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return req, fmt.Errorf("seed is not a number: %s", v)
+		}
+		req.Seed = &seed
+	}
+	if v := q.Get("count"); v != "" {
+		count, err := strconv.Atoi(v)
+		if err != nil {
+			return req, fmt.Errorf("count is not a number: %s", v)
+		}
+		req.Count = count
+	}
+	req.Share = q.Get("share") == "true"
+	req.Trace = q.Get("trace") == "true"
+	return req, nil
+}
+
+func (s *server) generate(w http.ResponseWriter, r *http.Request) {
+	var req GenerateRequest
+	switch r.Method {
+	case http.MethodGet:
+		var err error
+		req, err = queryRequest(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("bad request body: %s", err))
+			return
+		}
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s is not allowed", r.Method))
+		return
+	}
+	if req.Table == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("no table given"))
+		return
+	}
+	if req.Count == 0 {
+		req.Count = 1
+	}
+	if req.Count < 0 || req.Count > MAX_COUNT {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("count must be from 1 to %d", MAX_COUNT))
+		return
+	}
+
+	parsedTable, group, err := tables.Resolve(req.Table)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	resp := GenerateResponse{
+		Table:   parsedTable.Name,
+		Group:   group,
+		Results: []Result{},
+	}
+	if req.Seed != nil {
+		resp.Seed = *req.Seed
+	} else {
+		resp.Seed = rng.NewSeed()
+	}
+
+	// each result gets its own seed, drawn from the seed of the
+	// one before, the same as rtbl new
+	seed := resp.Seed
+	session := tables.NewSession()
+	for j := 0; j < req.Count; j++ {
+		if !req.Share {
+			session = tables.NewSession()
+		}
+		rolled := session.Table(parsedTable)
+		// arguments and variables are literal text, HTML escaped,
+		// so a request, e.g. a permalink, can neither run table
+		// markup nor put HTML in the result
+		if err := rolled.BindArgsEscaped(req.Args, html.EscapeString); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		for name, v := range req.Variables {
+			rolled.SetVariable(name, html.EscapeString(v))
+		}
+		session.Seed(seed)
+		var res Result
+		var html string
+		if req.Trace {
+			html, res.Trace = rolled.RollTrace(group)
+		} else {
+			html = rolled.Roll(group)
+		}
+		seed = session.Rand().Int63()
+		res.HTML = rolled.Header + html + rolled.Footer
+		res.Text, res.Markdown, err = render(res.HTML)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		resp.Results = append(resp.Results, res)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// the text and markdown renderings of a result
+func render(html string) (string, string, error) {
+	pto := html2text.NewPrettyTablesOptions()
+	pto.ColWidth = TEXT_WIDTH
+	text, err := html2text.FromString(html,
+		html2text.Options{
+			PrettyTables:        true,
+			PrettyTablesOptions: pto,
+		})
+	if err != nil {
+		return "", "", err
+	}
+	markdown, err := md.NewConverter("", true, nil).ConvertString(html)
+	return text, markdown, err
+}
+
+func (s *server) dice(w http.ResponseWriter, r *http.Request) {
+	if !onlyGet(w, r) {
+		return
+	}
+	expr := r.URL.Query().Get("expr")
+	if expr == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("no dice expression given"))
+		return
+	}
+	res, err := tables.BuiltinCall(tables.NewTable("dice"), "Dice", expr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"expr": expr, "result": res})
+}
@@ -0,0 +1,240 @@
+package server
+
+/*
+ * Test the HTTP JSON API, see server.go
+ */
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"rtbl/tables"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	if err := tables.LoadAllTables("../testdata/Tables"); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(NewHandler())
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// request a path, check the status and decode the JSON response into v
+func call(t *testing.T, srv *httptest.Server, method, path, body string, status int, v interface{}) {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != status {
+		t.Fatalf("%s %s: wanted status %d, have %d", method, path, status, resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("%s %s: response is %s not JSON", method, path, ct)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("%s %s: %s", method, path, err)
+	}
+}
+
+func TestListing(t *testing.T) {
+	srv := newTestServer(t)
+
+	var categories tables.TablesByCategory
+	call(t, srv, "GET", "/api/categories", "", http.StatusOK, &categories)
+	if len(categories["Tables"]) != len(tables.TableRegistry.Names()) {
+		t.Logf("wrong categories %v", categories)
+		t.Fail()
+	}
+
+	var names []string
+	call(t, srv, "GET", "/api/tables", "", http.StatusOK, &names)
+	if len(names) == 0 || names[0] != "citydefense" {
+		t.Logf("wrong table names %v", names)
+		t.Fail()
+	}
+
+	var info TableInfo
+	call(t, srv, "GET", "/api/tables/cn", "", http.StatusOK, &info)
+	if info.Name != "cn" || len(info.Groups) < 2 || info.Groups[0] != "Start" {
+		t.Logf("wrong table info %+v", info)
+		t.Fail()
+	}
+
+	var e map[string]string
+	call(t, srv, "GET", "/api/tables/Nowhere", "", http.StatusNotFound, &e)
+	if e["error"] == "" {
+		t.Log("no error message for a missing table")
+		t.Fail()
+	}
+	call(t, srv, "POST", "/api/tables", "", http.StatusMethodNotAllowed, &e)
+}
+
+func TestGenerate(t *testing.T) {
+	srv := newTestServer(t)
+
+	var resp GenerateResponse
+	call(t, srv, "GET", "/api/generate?table=Functions.Caps&trace=true", "", http.StatusOK, &resp)
+	if len(resp.Results) != 1 {
+		t.Fatalf("wanted 1 result, have %d", len(resp.Results))
+	}
+	res := resp.Results[0]
+	if res.HTML != "LOWER CASE" || res.Text != "LOWER CASE" || res.Markdown != "LOWER CASE" {
+		t.Logf("wrong renderings %+v", res)
+		t.Fail()
+	}
+	if res.Trace == nil || res.Trace.Result != "LOWER CASE" {
+		t.Logf("trace missing %+v", res.Trace)
+		t.Fail()
+	}
+
+	// the same seed gives the same results
+	var a, b GenerateResponse
+	call(t, srv, "GET", "/api/generate?table=cn.Name&count=5&seed=42", "", http.StatusOK, &a)
+	call(t, srv, "POST", "/api/generate", `{"table":"cn.Name","count":5,"seed":42}`, http.StatusOK, &b)
+	if a.Seed != 42 || len(a.Results) != 5 || len(b.Results) != 5 {
+		t.Fatalf("wrong batch %+v", a)
+	}
+	for j := range a.Results {
+		if a.Results[j].HTML != b.Results[j].HTML || a.Results[j].Trace != nil {
+			t.Logf("result %d is not replayed: %s %s", j, a.Results[j].HTML, b.Results[j].HTML)
+			t.Fail()
+		}
+	}
+
+	var e map[string]string
+	call(t, srv, "GET", "/api/generate", "", http.StatusBadRequest, &e)
+	call(t, srv, "GET", "/api/generate?table=Nowhere", "", http.StatusNotFound, &e)
+	call(t, srv, "GET", "/api/generate?table=cn&count=x", "", http.StatusBadRequest, &e)
+	call(t, srv, "GET", "/api/generate?table=cn&count=100000", "", http.StatusBadRequest, &e)
+	call(t, srv, "POST", "/api/generate", "{", http.StatusBadRequest, &e)
+}
+
+func TestDice(t *testing.T) {
+	srv := newTestServer(t)
+
+	var res map[string]string
+	call(t, srv, "GET", "/api/dice?expr="+url.QueryEscape("1d2+10"), "", http.StatusOK, &res)
+	if res["result"] != "11" && res["result"] != "12" {
+		t.Logf("wanted 11 or 12, have %v", res)
+		t.Fail()
+	}
+	call(t, srv, "GET", "/api/dice", "", http.StatusBadRequest, &res)
+}
+
+func TestGenerateArgs(t *testing.T) {
+	root := t.TempDir()
+	content := "@Terrain,Forest,Terrain,Forest,Swamp\n%Gold%,1\n:Start\n1,%Terrain% %Gold%\n"
+	if err := os.WriteFile(filepath.Join(root, "Monster.tab"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := tables.LoadAllTables(root); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(NewHandler())
+	defer srv.Close()
+
+	var resp GenerateResponse
+	call(t, srv, "GET", "/api/generate?table=Monster&arg=Swamp&var=Gold=9", "", http.StatusOK, &resp)
+	if resp.Results[0].HTML != "Swamp 9" {
+		t.Logf("wanted 'Swamp 9' have %q", resp.Results[0].HTML)
+		t.Fail()
+	}
+	call(t, srv, "POST", "/api/generate", `{"table":"Monster","args":["Terrain=1"]}`, http.StatusOK, &resp)
+	if resp.Results[0].HTML != "Forest 1" {
+		t.Logf("wanted 'Forest 1' have %q", resp.Results[0].HTML)
+		t.Fail()
+	}
+	// a permalink can not put markup, or HTML, in the result
+	content = "@Name,Fred,Name\n@Clan,Smith,Clan,Smith,O'Brien\n:Start\n1,<b>%Name%</b>%X% %Clan%\n"
+	if err := os.WriteFile(filepath.Join(root, "Hero.tab"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := tables.LoadAllTables(root); err != nil {
+		t.Fatal(err)
+	}
+	call(t, srv, "POST", "/api/generate",
+		`{"table":"Hero","args":["Name=<img src=x onerror=alert(1)>"],"variables":{"X":"<script>"}}`, http.StatusOK, &resp)
+	if resp.Results[0].HTML != "<b>&lt;img src=x onerror=alert(1)&gt;</b>&lt;script&gt; Smith" {
+		t.Logf("argument not escaped %q", resp.Results[0].HTML)
+		t.Fail()
+	}
+	call(t, srv, "POST", "/api/generate",
+		`{"table":"Hero","args":["Name={Loop~3,[Start]}","Clan=O'Brien"],"variables":{"X":"[Start]"}}`, http.StatusOK, &resp)
+	if resp.Results[0].HTML != "<b>{Loop~3,[Start]}</b>[Start] O&#39;Brien" {
+		t.Logf("argument evaluated as markup %q", resp.Results[0].HTML)
+		t.Fail()
+	}
+	var e map[string]string
+	call(t, srv, "GET", "/api/generate?table=Monster&arg=Ocean", "", http.StatusBadRequest, &e)
+}
+
+func TestUI(t *testing.T) {
+	srv := newTestServer(t)
+
+	for _, page := range []string{"/", "/app.js", "/style.css"} {
+		resp, err := http.Get(srv.URL + page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || len(body) == 0 {
+			t.Logf("%s: status %d, %d bytes", page, resp.StatusCode, len(body))
+			t.Fail()
+		}
+		if page == "/" && !strings.Contains(string(body), `src="app.js"`) {
+			t.Log("index page does not load app.js")
+			t.Fail()
+		}
+	}
+}
+
+// run with -race, requests are served at once
+func TestConcurrentGenerate(t *testing.T) {
+	srv := newTestServer(t)
+
+	var want GenerateResponse
+	call(t, srv, "GET", "/api/generate?table=cn.Name&count=3&seed=42", "", http.StatusOK, &want)
+	var wg sync.WaitGroup
+	for j := 0; j < 8; j++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := 0; k < 10; k++ {
+				resp, err := http.Get(srv.URL + "/api/generate?table=cn.Name&count=3&seed=42")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				var have GenerateResponse
+				err = json.NewDecoder(resp.Body).Decode(&have)
+				resp.Body.Close()
+				if err != nil || len(have.Results) != 3 {
+					t.Errorf("bad response %+v %v", have, err)
+					return
+				}
+				for n := range have.Results {
+					if have.Results[n].HTML != want.Results[n].HTML {
+						t.Errorf("seed 42 result %d is %q, alone it is %q",
+							n, have.Results[n].HTML, want.Results[n].HTML)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,147 @@
+// rtbl browser UI, everything is fetched from the JSON API
+// the permalink is the location hash; table, group, seed and p.Name=value
+
+const $ = (id) => document.getElementById(id);
+let current = null; // TableInfo of the selected table
+
+async function api(path, body) {
+  const opts = body ? { method: "POST", body: JSON.stringify(body) } : {};
+  const resp = await fetch(path, opts);
+  const data = await resp.json();
+  if (!resp.ok) throw new Error(data.error);
+  return data;
+}
+
+function showError(err) {
+  $("error").textContent = err ? err.message : "";
+  $("error").hidden = !err;
+}
+
+// tables are named by category, e.g. names.greek, build nested
+// <details> for each category with a link for every table
+async function loadTree() {
+  const names = await api("/api/tables");
+  const root = { dirs: {}, tables: [] };
+  for (const name of names) {
+    const parts = name.split(".");
+    let node = root;
+    for (const dir of parts.slice(0, -1)) {
+      node = node.dirs[dir] = node.dirs[dir] || { dirs: {}, tables: [] };
+    }
+    node.tables.push({ name, label: parts[parts.length - 1] });
+  }
+  $("tree").append(...treeNodes(root));
+}
+
+function treeNodes(node) {
+  const els = [];
+  for (const dir of Object.keys(node.dirs).sort()) {
+    const details = document.createElement("details");
+    const summary = document.createElement("summary");
+    summary.textContent = dir;
+    details.append(summary, ...treeNodes(node.dirs[dir]));
+    els.push(details);
+  }
+  for (const t of node.tables) {
+    const a = document.createElement("a");
+    a.href = "#table=" + encodeURIComponent(t.name);
+    a.textContent = t.label;
+    a.dataset.table = t.name;
+    els.push(a);
+  }
+  return els;
+}
+
+// select a table, build the group picker and the parameter form
+async function selectTable(name, group, values) {
+  current = await api("/api/tables/" + encodeURIComponent(name));
+  $("title").textContent = current.name;
+  for (const a of document.querySelectorAll("nav a")) {
+    a.classList.toggle("current", a.dataset.table === current.name);
+  }
+  $("group").replaceChildren(...current.groups.map((g) => new Option(g, g)));
+  $("group").value = group || "Start";
+  $("params").replaceChildren(...current.params.map((p) => paramInput(p, values[p.name])));
+  $("roll").hidden = false;
+  $("result").replaceChildren();
+}
+
+function paramInput(p, value) {
+  const label = document.createElement("label");
+  label.textContent = (p.prompt || p.name) + " ";
+  let input;
+  if (p.choices) {
+    input = document.createElement("select");
+    input.append(...p.choices.map((c) => new Option(c, c)));
+  } else {
+    input = document.createElement("input");
+  }
+  input.name = p.name;
+  input.value = value !== undefined ? value : p.default;
+  label.append(input);
+  return label;
+}
+
+function paramValues() {
+  const values = {};
+  for (const input of $("params").querySelectorAll("input, select")) {
+    values[input.name] = input.value;
+  }
+  return values;
+}
+
+function permalink(seed) {
+  const q = new URLSearchParams({ table: current.name, group: $("group").value });
+  if (seed !== undefined) q.set("seed", seed);
+  for (const [name, value] of Object.entries(paramValues())) q.set("p." + name, value);
+  return "#" + q.toString();
+}
+
+// roll on the selected group, results are the HTML made by Roll
+async function roll(seed) {
+  showError(null);
+  const values = paramValues();
+  const req = {
+    table: current.name + "." + $("group").value,
+    args: Object.entries(values).map(([name, value]) => name + "=" + value),
+  };
+  if (seed !== undefined) req.seed = Number(seed);
+  try {
+    const resp = await api("/api/generate", req);
+    $("result").innerHTML = resp.results[0].html;
+    $("seed").textContent = "seed " + resp.seed;
+    const link = permalink(resp.seed);
+    $("permalink").href = link;
+    history.replaceState(null, "", link);
+  } catch (err) {
+    showError(err);
+  }
+}
+
+// open the table, and roll, named by the location hash
+async function fromHash() {
+  const q = new URLSearchParams(location.hash.slice(1));
+  const name = q.get("table");
+  if (!name) return;
+  const values = {};
+  for (const [key, value] of q) {
+    if (key.startsWith("p.")) values[key.slice(2)] = value;
+  }
+  try {
+    await selectTable(name, q.get("group"), values);
+    if (q.has("seed")) await roll(q.get("seed"));
+  } catch (err) {
+    showError(err);
+  }
+}
+
+$("roll").addEventListener("submit", (e) => {
+  e.preventDefault();
+  roll();
+});
+$("permalink").addEventListener("click", (e) => {
+  e.preventDefault();
+  navigator.clipboard.writeText(location.href);
+});
+window.addEventListener("hashchange", fromHash);
+loadTree().then(fromHash, showError);
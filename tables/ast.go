@@ -0,0 +1,260 @@
+package tables
+
+/*
+ * Entry text is compiled once, when its group is closed, into
+ * nodes that are rendered on every roll, rather than scanning
+ * the text again each time it is rolled.
+ *
+ *   text          literal text
+ *   [Ref]         roll on a group, the reference is itself compiled
+ *   {Name~Args}   call a builtin, a lazy builtin evaluates its own arguments
+ *   %Var%         the value of a variable, %Var:03% formatted, see formatVariable
+ *   |Var op val|  assign to a variable
+ */
+
+import (
+	"fmt"
+	"strings"
+)
+
+type nodeKind int
+
+const (
+	NODE_TEXT nodeKind = iota
+	NODE_REF
+	NODE_BUILTIN
+	NODE_VARIABLE
+	NODE_ASSIGN
+)
+
+type node struct {
+	kind nodeKind
+	text string // literal text, or the name of a builtin or variable
+	op   string // operator of an assignment, or the format of a variable
+	body nodes  // the reference, builtin arguments or assigned value
+	args *Args  // the arguments of a lazy builtin
+}
+
+type nodes []*node
+
+// Args are the arguments of a lazy builtin, split as the builtin
+// separates them and each compiled, but not evaluated, see Builtin
+type Args struct {
+	src   string
+	parts []nodes
+	expr  *expr  // the first argument parsed, see Builtin.Expr
+	first string // the first argument as written, for errors
+	err   error  // parsing expr
+}
+
+// compile the arguments of a lazy builtin
+func compileArgs(b Builtin, s string) *Args {
+	a := &Args{src: s}
+	parts := b.Split(s)
+	for _, part := range parts {
+		a.parts = append(a.parts, compile(part))
+	}
+	if b.Expr {
+		a.first = parts[0]
+		a.expr, a.err = parseExpr(a.first)
+	}
+	return a
+}
+
+// the arguments as written
+func (a *Args) String() string { return a.src }
+
+// the number of arguments
+func (a *Args) Len() int { return len(a.parts) }
+
+// Eval renders argument j, each time it is called
+func (a *Args) Eval(t *Table, j int) string {
+	return t.render(a.parts[j])
+}
+
+// Cond evaluates the first argument as an expression, true or false
+func (a *Args) Cond(t *Table) (bool, error) {
+	v, err := a.value(t)
+	return v.truth(), err
+}
+
+// the value of the first argument as an expression
+func (a *Args) value(t *Table) (value, error) {
+	if a.err != nil {
+		return value{}, a.err
+	}
+	return a.expr.eval(t, a.first)
+}
+
+// the characters that start something other than literal text
+const SPECIAL_CHARS = "[{%|"
+
+// compile text into nodes, anything not well formed,
+// e.g. a % with no closing %, is literal text
+func compile(s string) nodes {
+	if s == "" {
+		return nil
+	}
+	if !strings.ContainsAny(s, SPECIAL_CHARS) {
+		return nodes{{kind: NODE_TEXT, text: s}}
+	}
+	var ns nodes
+	var text strings.Builder
+	flush := func() {
+		if text.Len() > 0 {
+			ns = append(ns, &node{kind: NODE_TEXT, text: text.String()})
+			text.Reset()
+		}
+	}
+
+	for j := 0; j < len(s); j++ {
+		switch s[j] {
+		case '[':
+			sub, last := findEndDelim(s[j+1:], "[", "]")
+			j += last + 1
+			flush()
+			ns = append(ns, &node{kind: NODE_REF, body: compile(sub)})
+		case '{':
+			sub, last := findEndDelim(s[j+1:], "{", "}")
+			j += last + 1
+			flush()
+			name, args := sub, ""
+			if idx := findUnnested(sub, '~'); idx != -1 {
+				name, args = sub[:idx], sub[idx+1:]
+			}
+			if b, ok := builtinsByName[strings.ToLower(name)]; ok && b.Lazy != nil {
+				// the builtin evaluates its own arguments
+				ns = append(ns, &node{kind: NODE_BUILTIN, text: name, args: compileArgs(b, args)})
+			} else {
+				ns = append(ns, &node{kind: NODE_BUILTIN, text: name, body: compile(args)})
+			}
+		case '%':
+			idx := strings.IndexByte(s[j+1:], '%')
+			if idx == -1 { // no closing percent, not a variable
+				text.WriteByte('%')
+				break
+			}
+			flush()
+			name, format := s[j+1:j+1+idx], ""
+			if k := strings.IndexByte(name, ':'); k != -1 {
+				name, format = name[:k], name[k+1:]
+			}
+			ns = append(ns, &node{kind: NODE_VARIABLE, text: name, op: format})
+			j += idx + 1
+		case '|':
+			// inline assignment |Name op value|, anything
+			// else is a literal pipe
+			last := findAssignEnd(s[j+1:])
+			if last == -1 {
+				text.WriteByte('|')
+				break
+			}
+			m := lexInlineAssign.FindStringSubmatch(s[j+1 : j+1+last])
+			if m == nil {
+				text.WriteByte('|')
+				break
+			}
+			value := s[j+1+len(m[0]) : j+1+last]
+			j += last + 1
+			flush()
+			ns = append(ns, &node{kind: NODE_ASSIGN, text: m[1], op: m[2], body: compile(value)})
+		default:
+			text.WriteByte(s[j])
+		}
+	}
+	flush()
+	return ns
+}
+
+// render the nodes as text, when a node fails only its
+// --ERROR message is returned
+func (t *Table) render(ns nodes) string {
+	if len(ns) == 1 && ns[0].kind == NODE_TEXT {
+		return ns[0].text
+	}
+	var b strings.Builder
+	for _, n := range ns {
+		if t.cancelled() { // e.g. Stop, the text so far is kept
+			break
+		}
+		switch n.kind {
+		case NODE_TEXT:
+			b.WriteString(n.text)
+		case NODE_REF:
+			b.WriteString(t.Roll(t.render(n.body)))
+		case NODE_BUILTIN:
+			node := t.traceBegin(&TraceNode{Kind: TRACE_BUILTIN, Name: n.text})
+			var args string
+			if n.args != nil {
+				args = n.args.String()
+			} else {
+				args = t.render(n.body)
+			}
+			if node != nil {
+				node.Args = args
+			}
+			var res string
+			var err error
+			if n.args != nil {
+				res, err = lazyCall(t, n.text, n.args)
+			} else {
+				res, err = BuiltinCall(t, n.text, args)
+			}
+			t.traceEnd(node, res, err)
+			if err != nil {
+				t.evalError("Calling Builtin", n.text+"("+args+")", err)
+				return "\n--ERROR Calling Builtin-- " + fmt.Sprintf("%s(%s): %s\n", n.text, args, err)
+			}
+			b.WriteString(res)
+		case NODE_VARIABLE:
+			node := t.traceBegin(&TraceNode{Kind: TRACE_VARIABLE, Name: n.text})
+			v, ok := t.GetVariable(n.text) // evaluated, see GetVariable
+			if !ok {
+				t.traceEnd(node, "", fmt.Errorf("does not exist"))
+				t.evalError("Accessing Variable", "%"+n.text+"%", fmt.Errorf("does not exist"))
+				return "\n--ERROR Accessing Variable-- %" + n.text + "% does not exist"
+			}
+			if n.op != "" {
+				var err error
+				if v, err = formatVariable(v, n.op); err != nil {
+					t.traceEnd(node, "", err)
+					t.evalError("Accessing Variable", "%"+n.text+":"+n.op+"%", err)
+					return "\n--ERROR Accessing Variable-- %" + n.text + ":" + n.op + "% " + err.Error()
+				}
+			}
+			t.traceEnd(node, v, nil)
+			b.WriteString(v)
+		case NODE_ASSIGN:
+			node := t.traceBegin(&TraceNode{Kind: TRACE_ASSIGN, Name: n.text, Modifier: n.op})
+			value := t.render(n.body)
+			if node != nil {
+				node.Args = value
+			}
+			res, err := t.AssignVariable(n.text, n.op, value)
+			t.traceEnd(node, res, err)
+			if err != nil {
+				t.evalError("Assigning Variable", "|"+n.text+n.op+value+"|", err)
+				return "\n--ERROR Assigning Variable-- " + fmt.Sprintf("|%s%s%s|: %s\n", n.text, n.op, value, err)
+			}
+		}
+	}
+	return b.String()
+}
+
+// compile every entry of the group, with its prefix and suffix
+func (g *Group) compile() {
+	g.nodes = make(map[string]nodes, len(g.items))
+	for _, item := range g.items {
+		s := g.Prefix + item.text + g.Suffix
+		g.nodes[s] = compile(s)
+	}
+}
+
+// the nodes of an entry rolled on the group, an entry
+// changed since the group was compiled is compiled again
+func (g *Group) compiled(s string) nodes {
+	if ns, ok := g.nodes[s]; ok {
+		return ns
+	}
+	return compile(s)
+}
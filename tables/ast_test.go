@@ -0,0 +1,127 @@
+package tables
+
+/*
+ * Test compiling entries, see ast.go
+ */
+import (
+	"strings"
+	"testing"
+)
+
+// a node as text, to compare with the expected nodes
+func (n *node) String() string {
+	var body []string
+	for _, b := range n.body {
+		body = append(body, b.String())
+	}
+	if n.args != nil { // each argument of a lazy builtin, split by ;
+		body = nil
+		for _, part := range n.args.parts {
+			var text []string
+			for _, b := range part {
+				text = append(text, b.String())
+			}
+			body = append(body, strings.Join(text, " ")+";")
+		}
+	}
+	switch n.kind {
+	case NODE_REF:
+		return "ref(" + strings.Join(body, " ") + ")"
+	case NODE_BUILTIN:
+		return "builtin " + n.text + "(" + strings.Join(body, " ") + ")"
+	case NODE_VARIABLE:
+		return "var " + n.text
+	case NODE_ASSIGN:
+		return "assign " + n.text + n.op + "(" + strings.Join(body, " ") + ")"
+	}
+	return "'" + n.text + "'"
+}
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"plain text", "'plain text'"},
+		{"a [Color] door", "'a ' ref('Color') ' door'"},
+		{"[Monster.Start=%Level%]", "ref('Monster.Start=' var Level)"},
+		{"{Cap~[Color], {LCase~X}}", "builtin Cap(ref('Color') ', ' builtin LCase('X'))"},
+		{"{Version}", "builtin Version()"},
+		{"|Gold+{Dice~1d6}|%Gold% gp", "assign Gold+(builtin Dice('1d6')) var Gold ' gp'"},
+		{"50% off | or not", "'50% off | or not'"},
+		{"a [Color", "'a ' ref('Color')"},
+		{"{If~%X%=1?{Dice~1d6}/no}", "builtin If(var X '=1'; builtin Dice('1d6'); 'no';)"},
+		{"{Loop~3,[Gem], }", "builtin Loop('3'; ref('Gem') ', ';)"},
+		{"{Select~%X%,1,a,b}", "builtin Select(var X; '1'; 'a'; 'b';)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			var have []string
+			for _, n := range compile(tt.input) {
+				have = append(have, n.String())
+			}
+			if strings.Join(have, " ") != tt.want {
+				t.Logf("compile %q wanted %s, have %s", tt.input, tt.want, strings.Join(have, " "))
+				t.Fail()
+			}
+		})
+	}
+}
+
+// the compiled entries are rendered, an entry changed
+// after its group was closed still rolls
+func TestCompiledEntries(t *testing.T) {
+	tbl := NewTable("compiled")
+	tbl.AddVariable("Level", "2")
+	g := NewGroup(":Start")
+	g.AddItem(1, 1, "level %Level%")
+	g.Prefix = "<"
+	tbl.AddGroup(g)
+	if _, ok := g.nodes["<level %Level%"]; !ok {
+		t.Log("entry not compiled when the group was closed")
+		t.Fail()
+	}
+	if res := tbl.Roll("Start"); res != "<level 2" {
+		t.Logf("wanted '<level 2' have %q", res)
+		t.Fail()
+	}
+	g.Suffix = ">"
+	if res := tbl.Roll("Start"); res != "<level 2>" {
+		t.Logf("wanted '<level 2>' have %q", res)
+		t.Fail()
+	}
+}
+
+// the tables under testdata, each rolled from its Start group
+func BenchmarkRoll(b *testing.B) {
+	root := "../testdata/Tables"
+	paths, err := FindTables(root)
+	if err != nil {
+		b.Fatal(err)
+	}
+	r := NewTableList(root, paths)
+	for _, name := range r.Names() {
+		tbl, err := r.Parse(name)
+		if err != nil {
+			continue
+		}
+		b.Run(name, func(b *testing.B) {
+			for j := 0; j < b.N; j++ {
+				tbl.Roll("Start")
+			}
+		})
+	}
+}
+
+func BenchmarkEvaluate(b *testing.B) {
+	tbl := NewTable("bench")
+	tbl.AddVariable("Level", "3")
+	g := NewGroup(";Color")
+	g.AddItem(1, 1, "red")
+	g.AddItem(1, 1, "green")
+	tbl.AddGroup(g)
+	text := "A {Cap~[Color]} door, level %Level%|Level+1| {Dice~2d6} gp"
+	for j := 0; j < b.N; j++ {
+		tbl.Evaluate(text)
+	}
+}
@@ -1,20 +1,17 @@
 package tables
 
 import (
-	"bufio"
+	"context"
 	_ "embed"
 	"fmt"
 	"math"
-	"os"
 	"regexp"
+	"rtbl/datasets"
 	"rtbl/stringsext"
 	"sort"
 	"strconv"
 	"strings"
 	"unicode"
-
-	"github.com/Knetic/govaluate"
-	"github.com/nboughton/go-roll"
 )
 
 //go:embed version.txt
@@ -22,27 +19,81 @@ var Version string // have to build in the version so Version can print it
 
 type BuiltInFunc func(*Table, string) (string, error)
 
+// LazyFunc is given the arguments compiled but not evaluated
+type LazyFunc func(*Table, *Args) (string, error)
+
 type Builtin struct {
 	Name  string
 	BFunc BuiltInFunc
+	// a lazy builtin is called with its arguments split by Split,
+	// compiled once, and evaluates only the ones it uses with
+	// Args.Eval, e.g. If rolls only the chosen result
+	Lazy  LazyFunc
+	Split func(string) []string
+	// the first argument of a lazy builtin is an expression,
+	// parsed once as written, see Args.Cond
+	Expr bool
+}
+
+// builtins by lower case name, made once from FunctionRegistry
+var builtinsByName map[string]Builtin
+
+func init() {
+	builtinsByName = make(map[string]Builtin)
+	for _, b := range FunctionRegistry() {
+		builtinsByName[strings.ToLower(b.Name)] = b
+	}
 }
 
 func BuiltinCall(t *Table, fname, args string) (string, error) {
-	lfr := len(FunctionRegistry())
-	for j := 0; j < lfr; j++ {
-		if strings.EqualFold(fname, FunctionRegistry()[j].Name) {
-			/*
-				defer func() { // recovers panic
-					if e := recover(); e != nil {
-						fmt.Printf("Recovered from panic in builtin function \"%s~%s\" in %s:%s", fname, args, t.Name, e)
-					}
-				}()
-			*/
-			res, err := FunctionRegistry()[j].BFunc(t, args)
-			return res, err
+	b, ok := builtinsByName[strings.ToLower(fname)]
+	if !ok {
+		return "", fmt.Errorf("No builtin function named %s", fname)
+	}
+	if b.Lazy != nil {
+		return b.Lazy(t.inSession(), compileArgs(b, args))
+	}
+	return b.BFunc(t, args)
+}
+
+// call a lazy builtin with its compiled arguments
+func lazyCall(t *Table, fname string, args *Args) (string, error) {
+	return builtinsByName[strings.ToLower(fname)].Lazy(t, args)
+}
+
+// is there a builtin function with this name
+func isBuiltin(fname string) bool {
+	_, ok := builtinsByName[strings.ToLower(fname)]
+	return ok
+}
+
+// split the condition of If and IIf from its results,
+// Expr?Result1<sep>Result2, results may be missing
+func splitCond(sep byte) func(string) []string {
+	return func(s string) []string {
+		idx := findUnnested(s, '?')
+		if idx == -1 {
+			return []string{s}
 		}
+		expr, r := s[:idx], s[idx+1:]
+		if idx := findUnnested(r, sep); idx != -1 {
+			return []string{expr, r[:idx], r[idx+1:]}
+		}
+		return []string{expr, r}
+	}
+}
+
+// split at the first comma, Loop~X,Value and While~Expr,Value
+func splitFirst(s string) []string {
+	if idx := findUnnested(s, ','); idx != -1 {
+		return []string{s[:idx], s[idx+1:]}
 	}
-	return "", fmt.Errorf("No builtin function named %s", fname)
+	return []string{s}
+}
+
+// split at every comma
+func splitAll(s string) []string {
+	return splitUnnested(s, ',')
 }
 
 var (
@@ -100,65 +151,54 @@ func stripInsignificantDigits(s string) string {
 }
 
 func helperInputList(t *Table, s string) (string, error) {
+	// {InputList~Def,Prompt,Option,...}
+	// Def is the number of the default option, from 0, the options
+	// are chosen from with the Prompter of the session
 	options := strings.Split(s, ",")
+	if len(options) < 3 {
+		return "", fmt.Errorf("InputList~Def,Prompt,Option,...: no Option in %s", s)
+	}
 	def, err := strconv.Atoi(options[0])
 	if err != nil {
-		return "", fmt.Errorf("InpuList~Def,Prompt,Option,... %s is not a number", options[0])
+		return "", fmt.Errorf("InputList~Def,Prompt,Option,... %s is not a number", options[0])
 	}
-	fmt.Println(options[1])
-	prefix := ""
-	for num, p := range options[2:] {
-		if num == def {
-			prefix = "*"
-		} else {
-			prefix = " "
-		}
-		prefix += fmt.Sprintf("%d)", num)
-		fmt.Println(prefix, p)
+	if def < 0 || def >= len(options)-2 {
+		return "", fmt.Errorf("InputList~Def,Prompt,Option,... there is no option %d", def)
+	}
+	p := &Param{Name: "InputList", Default: options[def+2], Prompt: options[1], Choices: options[2:]}
+	if t == nil || t.session == nil || t.session.prompt == nil {
+		return p.Default, nil
+	}
+	ctx := t.session.ctx
+	if ctx == nil {
+		ctx = context.Background()
 	}
-	reader := bufio.NewReader(os.Stdin)
-	// ReadString will block until the delimiter is entered
-	input, err := reader.ReadString('\n')
+	input, err := t.session.prompt.Prompt(ctx, t.Name, p)
 	if err != nil {
-		return "", fmt.Errorf("An error occured while reading input. %s", err)
+		return "", err
 	}
+	return p.value(input)
+}
 
-	// remove the delimeter from the string
-	input = strings.TrimSuffix(input, "\n")
-	var choice int
-	if len(input) == 0 {
-		choice = def
-	} else {
-		choice, err = strconv.Atoi(input)
+// a dataset builtin, given the datasets of the session, datasets
+// made in one generation are never seen by another
+func dsBuiltin(f func(*datasets.Registry, string) (string, error)) BuiltInFunc {
+	return func(t *Table, s string) (string, error) {
+		if t == nil || t.session == nil {
+			return "", fmt.Errorf("datasets are only kept in a session")
+		}
+		return f(t.session.Datasets(), s)
 	}
-	return options[choice+2], nil
 }
 
-func evaulateExpr(t *Table, s string) (interface{}, error) {
-	//convert TableSmith expression to a golang expression to evualte results
-	// 1. transform expression syntax
-	// 2. add all variables as paramters to expression
-	// 3. call  govaluate pkg to calc result
-	s = strings.Replace(s, "%", "", -1)
-	// TableSmith uses single equal (=) for equality
-	// govaluate uses double (==)
-	s = strings.Replace(s, "=", "==", -1)
-	// create an expression from the transformed input string
-	expression, err := govaluate.NewEvaluableExpression(s)
-	if err != nil {
-		return 0.0, fmt.Errorf("ERROR Expr evaluation:%s, %s", s, err)
-	}
-	// get any variables needed to evaluate expression
-	parameters := make(map[string]interface{}, len(t.Variables))
-	for n, v := range t.Variables {
-		ival, end := stringsext.Strtoi(v)
-		if end != len(v) {
-			continue // this is not a number....
-		}
-		parameters[n] = ival
+// are a and b the same number, or the same text ignoring case
+func sameValue(a, b string) bool {
+	fa, aok := parseNumber(a)
+	fb, bok := parseNumber(b)
+	if aok && bok {
+		return fa == fb
 	}
-	// evaulate and return result
-	return expression.Evaluate(parameters)
+	return strings.EqualFold(a, b)
 }
 
 // Array of BuiltIn Functions
@@ -198,14 +238,17 @@ func FunctionRegistry() []Builtin {
 		},
 		{
 			Name: "Calc",
-			BFunc: func(t *Table, s string) (string, error) {
+			Lazy: func(t *Table, a *Args) (string, error) {
 				//{Calc~Expr}
-				// reaplce variables with values
-				// calc value
-				// convert value to string and return
-				res, err := evaulateExpr(t, s)
-				return fmt.Sprintf("%s", res), err
+				// variables are values of the expression, see expr.go
+				v, err := a.value(t)
+				if err != nil {
+					return "", err
+				}
+				return v.String(), nil
 			},
+			Split: func(s string) []string { return []string{s} },
+			Expr:  true,
 		},
 		{
 			Name: "Cap",
@@ -274,20 +317,18 @@ func FunctionRegistry() []Builtin {
 		{
 			Name: "Dice",
 			BFunc: func(t *Table, s string) (string, error) {
-				// roll.FromString does not support math operators
+				// rollDice does not support math operators
 				// so i will removethem first and process them later
 				maths := lexMath.FindAllString(s, -1)
 				if len(maths) > 0 {
 					j := strings.Index(s, maths[0]) // get index of first match
 					s = s[:j]
 				}
-				// get random roll
-				res, err := roll.FromString(s)
+				// get random roll, summing all the kept dice
+				sum, err := rollDice(t.random(), s)
 				if err != nil {
 					return "", err
 				}
-				// sum all the rolls
-				sum := res.Sum()
 				var fact int
 				// perform post RNG math
 				if len(maths) > 0 {
@@ -322,6 +363,39 @@ func FunctionRegistry() []Builtin {
 				return strconv.Itoa(sum), nil
 			},
 		},
+		{
+			Name:  "DSAdd",
+			BFunc: dsBuiltin((*datasets.Registry).DSAdd),
+		},
+		{
+			Name:  "DSAddNR",
+			BFunc: dsBuiltin((*datasets.Registry).DSAddNR),
+		},
+		{
+			Name:  "DSCalc",
+			BFunc: dsBuiltin((*datasets.Registry).DSCalc),
+		},
+		{
+			Name:  "DSCount",
+			BFunc: dsBuiltin((*datasets.Registry).DSCount),
+		},
+		{
+			Name:  "DSCreate",
+			BFunc: dsBuiltin((*datasets.Registry).DSCreate),
+		},
+		{
+			Name:  "DSGet",
+			BFunc: dsBuiltin((*datasets.Registry).DSGet),
+		},
+		{
+			Name: "DSRandomize",
+			BFunc: func(t *Table, s string) (string, error) {
+				// shuffled with the random source of the session
+				return dsBuiltin(func(r *datasets.Registry, s string) (string, error) {
+					return r.DSRandomize(t.random(), s)
+				})(t, s)
+			},
+		},
 		{
 			Name: "Floor",
 			BFunc: func(t *Table, s string) (string, error) {
@@ -348,26 +422,48 @@ func FunctionRegistry() []Builtin {
 		},
 		{
 			Name: "If",
-			BFunc: func(t *Table, s string) (string, error) {
+			Lazy: func(t *Table, a *Args) (string, error) {
 				// {If~Expr ? Result1/Result2}
-				expr := stringsext.First(s, "?")
-				r := stringsext.Rest(s, "?")
-				result := strings.Split(r, "/")
-				if len(result) == 1 { // append a nil false result
-					result = append(result, "")
-				}
-				// evaulate any other builtin calls
-				expr = t.Evaluate(expr)
-				expr = strings.Replace(expr, "%", "", -1)
-				expr = strings.Replace(expr, "=", "==", -1)
-
-				res, err := evaulateExpr(t, expr)
-
-				if res == true {
-					return fmt.Sprintf("%v", result[0]), err
+				// only the chosen result is evaluated
+				ok, err := a.Cond(t)
+				if err != nil {
+					return "", err
+				}
+				j := 2 // a missing result is nil
+				if ok {
+					j = 1
+				}
+				if j < a.Len() {
+					return a.Eval(t, j), nil
+				}
+				return "", nil
+			},
+			Split: splitCond('/'),
+			Expr:  true,
+		},
+		{
+			Name: "IIf",
+			Lazy: func(t *Table, a *Args) (string, error) {
+				// {IIf~Expr ? Result1 : Result2}
+				// as If, results may hold a /
+				if a.Len() == 1 {
+					return "", fmt.Errorf("IIf~Expr?Result1:Result2: no ? in %s", a)
+				}
+				ok, err := a.Cond(t)
+				if err != nil {
+					return "", err
+				}
+				j := 2
+				if ok {
+					j = 1
+				}
+				if j < a.Len() {
+					return a.Eval(t, j), nil
 				}
-				return fmt.Sprintf("%v", result[1]), err
+				return "", nil
 			},
+			Split: splitCond(':'),
+			Expr:  true,
 		},
 		{
 			Name:  "InputList",
@@ -411,20 +507,98 @@ func FunctionRegistry() []Builtin {
 			},
 		},
 		{
-			Name: "Loop",
+			Name: "ListAdd",
+			BFunc: func(t *Table, s string) (string, error) {
+				//{ListAdd~Name,Item}
+				idx := strings.Index(s, ",")
+				if idx == -1 {
+					return "", fmt.Errorf("ListAdd~Name,Item: no Item in %s", s)
+				}
+				t.AppendVariable(strings.TrimSpace(s[:idx]), s[idx+1:])
+				return "", nil
+			},
+		},
+		{
+			Name: "ListCount",
+			BFunc: func(t *Table, s string) (string, error) {
+				//{ListCount~Name}
+				items, err := t.listItems(strings.TrimSpace(s))
+				if err != nil {
+					return "", fmt.Errorf("ListCount~%s", err)
+				}
+				return strconv.Itoa(len(items)), nil
+			},
+		},
+		{
+			Name: "ListItem",
+			BFunc: func(t *Table, s string) (string, error) {
+				//{ListItem~Name,N} the Nth item, from 1
+				idx := strings.Index(s, ",")
+				if idx == -1 {
+					return "", fmt.Errorf("ListItem~Name,N: no N in %s", s)
+				}
+				items, err := t.listItems(strings.TrimSpace(s[:idx]))
+				if err != nil {
+					return "", fmt.Errorf("ListItem~%s", err)
+				}
+				n, err := strconv.Atoi(strings.TrimSpace(s[idx+1:]))
+				if err != nil {
+					return "", fmt.Errorf("ListItem~%s: N is not a number", s)
+				}
+				if n < 1 || n > len(items) {
+					return "", fmt.Errorf("ListItem~%s: the list has %d items", s, len(items))
+				}
+				return items[n-1], nil
+			},
+		},
+		{
+			Name: "ListJoin",
+			BFunc: func(t *Table, s string) (string, error) {
+				//{ListJoin~Name,Separator}
+				name, sep := s, LIST_SEPARATOR
+				if idx := strings.Index(s, ","); idx != -1 {
+					name, sep = s[:idx], s[idx+1:]
+				}
+				items, err := t.listItems(strings.TrimSpace(name))
+				if err != nil {
+					return "", fmt.Errorf("ListJoin~%s", err)
+				}
+				return strings.Join(items, sep), nil
+			},
+		},
+		{
+			Name: "ListPick",
 			BFunc: func(t *Table, s string) (string, error) {
+				//{ListPick~Name} a random item
+				items, err := t.listItems(strings.TrimSpace(s))
+				if err != nil {
+					return "", fmt.Errorf("ListPick~%s", err)
+				}
+				if len(items) == 0 {
+					return "", nil
+				}
+				return items[t.random().Intn(len(items))], nil
+			},
+		},
+		{
+			Name: "Loop",
+			Lazy: func(t *Table, a *Args) (string, error) {
 				//{Loop~X,Value}
-				args := strings.SplitN(s, ",", 2)
-				max, err := strconv.Atoi(args[0])
+				// Value is evaluated again on each loop
+				if a.Len() == 1 {
+					return "", fmt.Errorf("Loop~X,Value: no Value in %s", a)
+				}
+				max, err := strconv.Atoi(strings.TrimSpace(a.Eval(t, 0)))
 				if err != nil {
 					return "", err
 				}
-				var ret string
-				for j := 0; j < max; j++ {
-					ret += args[1]
+				var ret strings.Builder
+				for j := 0; j < max && !t.cancelled(); j++ {
+					ret.WriteString(a.Eval(t, 1))
 				}
-				return ret, nil
+				return ret.String(), nil
 			},
+			Split: splitFirst,
 		},
 		{
 			Name: "Mid",
@@ -447,6 +621,9 @@ func FunctionRegistry() []Builtin {
 			Name: "OrderAsc",
 			BFunc: func(t *Table, s string) (string, error) {
 				//OrderAsc~"X",Text
+				if len(s) < 4 {
+					return "", nil
+				}
 				delim := string(s[1]) // delimiter must be 1 char, in quotes
 				// s[0] and s[2] are the quotes
 				if s[3] != ',' {
@@ -463,6 +640,9 @@ func FunctionRegistry() []Builtin {
 			Name: "OrderDesc",
 			BFunc: func(t *Table, s string) (string, error) {
 				//OrderAsc~"X",Text
+				if len(s) < 4 {
+					return "", nil
+				}
 				delim := string(s[1]) // delimiter must be 1 char, in quotes
 				if s[3] != ',' {
 					return "", fmt.Errorf("OrderDesc~%s is missing a delimiter", s)
@@ -639,13 +819,27 @@ func FunctionRegistry() []Builtin {
 			},
 		},
 		{
-			Name: "TODO Select",
-			BFunc: func(t *Table, s string) (string, error) {
+			Name: "Select",
+			Lazy: func(t *Table, a *Args) (string, error) {
 				//{Select~Expr1,Value1,Result1,Value2,Result2,...,Default}
-				//args := strings.Split(s, ",")
-
+				// the Result of the first Value equal to Expr1, or
+				// Default, is the only one evaluated
+				if a.Len() < 3 {
+					return "", fmt.Errorf("Select~Expr,Value,Result,...: not enough arguments in %s", a)
+				}
+				expr := strings.TrimSpace(a.Eval(t, 0))
+				j := 1
+				for ; j+1 < a.Len(); j += 2 {
+					if sameValue(expr, strings.TrimSpace(a.Eval(t, j))) {
+						return a.Eval(t, j+1), nil
+					}
+				}
+				if j < a.Len() { // the default
+					return a.Eval(t, j), nil
+				}
 				return "", nil
 			},
+			Split: splitAll,
 		},
 		{
 			Name: "Space",
@@ -691,7 +885,18 @@ func FunctionRegistry() []Builtin {
 		{
 			Name: "Status",
 			BFunc: func(t *Table, s string) (string, error) {
-				return s + "<br><br>", nil
+				return s, nil
+			},
+		},
+		{
+			Name: "Stop",
+			BFunc: func(t *Table, s string) (string, error) {
+				// {Stop~Message}
+				// nothing more is generated, Message is the last text
+				if t.session != nil {
+					t.session.stop = true
+				}
+				return s, nil
 			},
 		},
 		{
@@ -771,5 +976,33 @@ func FunctionRegistry() []Builtin {
 				return "0", nil
 			},
 		},
+		{
+			Name: "While",
+			Lazy: func(t *Table, a *Args) (string, error) {
+				//{While~Expr,Value}
+				// Value is evaluated on each loop, until Expr is false
+				if a.Len() == 1 {
+					return "", fmt.Errorf("While~Expr,Value: no Value in %s", a)
+				}
+				max := t.maxLoops()
+				var ret strings.Builder
+				for j := 0; !t.cancelled(); j++ {
+					ok, err := a.Cond(t)
+					if err != nil {
+						return "", err
+					}
+					if !ok {
+						break
+					}
+					if j == max {
+						return "", fmt.Errorf("While~%s: still true after %d loops", a, max)
+					}
+					ret.WriteString(a.Eval(t, 1))
+				}
+				return ret.String(), nil
+			},
+			Split: splitFirst,
+			Expr:  true,
+		},
 	}
 }
@@ -6,7 +6,9 @@ package tables
  * Functions are in the array 'FunctionRegistry'
  */
 import (
+	"rtbl/rng"
 	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -19,6 +21,8 @@ func TestDice(t *testing.T) {
 		{input: "2d12+4", min: 6, max: 28},
 		{input: "4d6Dl1+10", min: 13, max: 28},
 		{input: "4d10Kh3Dl1", min: 2, max: 20},
+		{input: "d20", min: 1, max: 20},
+		{input: "d6+1", min: 2, max: 7},
 	}
 
 	for tcase, tt := range tests {
@@ -42,6 +46,29 @@ func TestDice(t *testing.T) {
 	}
 }
 
+func TestDiceSeeded(t *testing.T) {
+	roll := func() []string {
+		rng.Seed(1234)
+		var res []string
+		for _, d := range []string{"3d6", "4d6Dl1+10", "1d100", "3d6X6"} {
+			r, err := BuiltinCall(nil, "Dice", d)
+			if err != nil {
+				t.Fatalf("%s failed: %s", d, err)
+			}
+			res = append(res, r)
+		}
+		return res
+	}
+	first := roll()
+	second := roll()
+	for j := range first {
+		if first[j] != second[j] {
+			t.Logf("Case %d: same seed rolled %s then %s", j, first[j], second[j])
+			t.Fail()
+		}
+	}
+}
+
 func TestAorAn(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -144,6 +171,94 @@ func TestFloor(t *testing.T) {
 	}
 }
 
+// only the chosen result of If is evaluated, with its side effects
+func TestIf(t *testing.T) {
+	tbl := NewTable("if")
+	tbl.AddVariable("Count", "0")
+	tbl.AddVariable("Foo", "112")
+	tbl.AddVariable("Race", "Half-Orc")
+	tbl.AddVariable("Name", "O'Brien")
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{input: "{If~1=1 ?|Count+1|yes/|Count+10|no} %Count%", expected: "yes 1"},
+		{input: "{If~%Race% ~ Half*?half/full}", expected: "half"},
+		{input: "{If~%Race%=Half-Orc?yes/no}", expected: "yes"},
+		{input: "{If~%Race% = \"Half-Orc\" and %Name% != Smith?yes/no}", expected: "yes"},
+		{input: "{If~%Name% = \"O'Brien\"?{Calc~Length(%Name%)}/no}", expected: "7"},
+		{input: "{If~1=2 ?|Count+1|yes/|Count+10|no} %Count%", expected: "no 10"},
+		{input: "{If~%Foo% > 100 ?{UCase~big}/small}", expected: "BIG"},
+		{input: "{If~%Foo% > 200 ?big}", expected: ""},
+		{input: "{If~{Abs~-3} = 3 ?[Size=1]/[Size=2]}", expected: "one"},
+	}
+	g := NewGroup(":Size")
+	g.AddItem(1, 1, "one")
+	g.AddItem(2, 2, "two")
+	tbl.AddGroup(g)
+
+	for tcase, tt := range tests {
+		res := tbl.Evaluate(tt.input)
+		if res != tt.expected {
+			t.Logf("Case %d: %s wanted %q, have %q", tcase, tt.input, tt.expected, res)
+			t.Fail()
+		}
+	}
+}
+
+func TestIIf(t *testing.T) {
+	tbl := NewTable("iif")
+	tbl.AddVariable("Foo", "112")
+	tbl.AddVariable("level", "7")
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{input: "{IIf~%Foo% > 100?1/2:3/4}", expected: "1/2"},
+		{input: "{IIf~%Foo% < 100?1/2:3/4}", expected: "3/4"},
+		{input: "{IIf~%Foo% < 100?yes}", expected: ""},
+		{input: "{IIf~1=1?|Foo=1|a:|Foo=2|b}%Foo%", expected: "a1"},
+		{input: "{IIf~1=1?%level:03%:no}", expected: "007"},
+		{input: "{IIf~%level:03% = 7?%level:,%:%level:5%}", expected: "7"},
+		{input: "{IIf~1=2?50% off:20% off}", expected: "20% off"},
+	}
+	for tcase, tt := range tests {
+		res := tbl.Evaluate(tt.input)
+		if res != tt.expected {
+			t.Logf("Case %d: %s wanted %q, have %q", tcase, tt.input, tt.expected, res)
+			t.Fail()
+		}
+	}
+	if _, err := BuiltinCall(tbl, "IIf", "1=1"); err == nil {
+		t.Log("IIf with no ? is not an error")
+		t.Fail()
+	}
+}
+
+func TestInputList(t *testing.T) {
+	// without a Prompter the default is chosen
+	res, err := BuiltinCall(nil, "InputList", "1,Which weapon,sword,axe,bow")
+	if err != nil || res != "axe" {
+		t.Logf("wanted the default axe, have %q %v", res, err)
+		t.Fail()
+	}
+	tbl := NewTable("input")
+	for _, answer := range []string{"bow", "3"} {
+		s := NewSession()
+		s.SetPrompter(fixedPrompter(answer))
+		if res := s.Table(tbl).Evaluate("{InputList~1,Which weapon,sword,axe,bow}"); res != "bow" {
+			t.Logf("answered %s wanted bow, have %q", answer, res)
+			t.Fail()
+		}
+	}
+	for _, args := range []string{"x,Which,a", "3,Which,a,b", "0,Which"} {
+		if _, err := BuiltinCall(nil, "InputList", args); err == nil {
+			t.Logf("InputList~%s is not an error", args)
+			t.Fail()
+		}
+	}
+}
+
 func TestIsNumber(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -248,6 +363,31 @@ func TestLength(t *testing.T) {
 	}
 }
 
+// each loop of Loop rolls again
+func TestLoop(t *testing.T) {
+	tbl := NewTable("loop")
+	tbl.AddVariable("N", "3")
+	g := NewGroup(":!Gem")
+	g.AddItem(1, 1, "a")
+	g.AddItem(2, 2, "b")
+	g.AddItem(3, 3, "c")
+	tbl.AddGroup(g)
+
+	res := tbl.Evaluate("{Loop~%N%,[Gem]}")
+	if len(res) != 3 || !strings.Contains(res, "a") || !strings.Contains(res, "b") || !strings.Contains(res, "c") {
+		t.Logf("Loop rolled %q, wanted each gem once", res)
+		t.Fail()
+	}
+	if res := tbl.Evaluate("{Loop~2,x|N+1|}%N%"); res != "xx5" {
+		t.Logf("Loop wanted xx5, have %q", res)
+		t.Fail()
+	}
+	if _, err := BuiltinCall(tbl, "Loop", "3"); err == nil {
+		t.Log("Loop with no value is not an error")
+		t.Fail()
+	}
+}
+
 func TestMid(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -539,6 +679,36 @@ func TestReplace(t *testing.T) {
 	}
 }
 
+func TestSelect(t *testing.T) {
+	tbl := NewTable("select")
+	tbl.AddVariable("Class", "Wizard")
+	tbl.AddVariable("Level", "3")
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{input: "{Select~%Class%,Fighter,sword,Wizard,staff,dagger}", expected: "staff"},
+		{input: "{Select~%Class%,fighter,sword,WIZARD,staff}", expected: "staff"},
+		{input: "{Select~Thief,Fighter,sword,Wizard,staff,dagger}", expected: "dagger"},
+		{input: "{Select~Thief,Fighter,sword,Wizard,staff}", expected: ""},
+		{input: "{Select~%Level%,3.0,third,3,not first}", expected: "third"},
+		{input: "{Select~{Abs~-2},1,|Level=1|,2,|Level=2|,|Level=0|}%Level%", expected: "2"},
+		{input: "{Select~1,1,{Left~2,abc},2,b}", expected: "ab"},
+		{input: "{Select~NaN,nan,text,other}", expected: "text"},
+	}
+	for tcase, tt := range tests {
+		res := tbl.Evaluate(tt.input)
+		if res != tt.expected {
+			t.Logf("Case %d: %s wanted %q, have %q", tcase, tt.input, tt.expected, res)
+			t.Fail()
+		}
+	}
+	if _, err := BuiltinCall(tbl, "Select", "a,b"); err == nil {
+		t.Log("Select with no Result is not an error")
+		t.Fail()
+	}
+}
+
 func TestSpace(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -623,6 +793,43 @@ func TestSqrt(t *testing.T) {
 	}
 }
 
+func TestStop(t *testing.T) {
+	tbl := NewTable("stop")
+	g := NewGroup(":Start")
+	g.AddItem(1, 1, "a [Middle] d")
+	tbl.AddGroup(g)
+	g = NewGroup(":Middle")
+	g.AddItem(1, 1, "b{Stop~ c} [Never]")
+	tbl.AddGroup(g)
+	g = NewGroup(":Never")
+	g.AddItem(1, 1, "{Reset~Start}never")
+	tbl.AddGroup(g)
+
+	s := NewSession()
+	res := s.Table(tbl).Roll("Start")
+	if res != "a b c" {
+		t.Logf("Stop wanted %q, have %q", "a b c", res)
+		t.Fail()
+	}
+	if !s.Stopped() || len(s.Errors()) != 0 {
+		t.Logf("session stopped %v, errors %v", s.Stopped(), s.Errors())
+		t.Fail()
+	}
+	// the next generation of the session is not stopped
+	if res := s.Table(tbl).Roll("Start"); res != "a b c" {
+		t.Logf("Stop carried over to the next generation, have %q", res)
+		t.Fail()
+	}
+	if res := s.Table(tbl).Evaluate("x [Never]"); res != "x never" || s.Stopped() {
+		t.Logf("Stop carried over to Evaluate, have %q", res)
+		t.Fail()
+	}
+	if res := tbl.Roll("Start"); res != "a b c" {
+		t.Logf("Stop carried over to a new session, have %q", res)
+		t.Fail()
+	}
+}
+
 func TestTitle(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -734,3 +941,24 @@ func TestVowelStart(t *testing.T) {
 		})
 	}
 }
+
+func TestWhile(t *testing.T) {
+	tbl := NewTable("while")
+	tbl.AddVariable("N", "0")
+	if res := tbl.Evaluate("{While~%N% < 3,x|N+1|}%N%"); res != "xxx3" {
+		t.Logf("While wanted xxx3, have %q", res)
+		t.Fail()
+	}
+	if res := tbl.Evaluate("{While~%N% > 0,x}"); res != "" {
+		t.Logf("While wanted nothing, have %q", res)
+		t.Fail()
+	}
+
+	s := NewSession()
+	s.SetMaxLoops(5)
+	res := s.Table(tbl).Evaluate("{While~1=1,x}")
+	if !strings.Contains(res, "still true after 5 loops") || len(s.Errors()) != 1 {
+		t.Logf("endless While wanted an error, have %q", res)
+		t.Fail()
+	}
+}
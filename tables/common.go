@@ -1,6 +1,7 @@
 package tables
 
 import (
+	"path/filepath"
 	"strings"
 )
 
@@ -9,8 +10,8 @@ import (
  * the file path
  */
 func makeName(path, root string) string {
-	name := strings.TrimSuffix(path, ".tab")
-	name = strings.TrimPrefix(name, root)
+	name := strings.TrimSuffix(filepath.ToSlash(filepath.Clean(path)), ".tab")
+	name = strings.TrimPrefix(name, filepath.ToSlash(filepath.Clean(root)))
 	name = strings.TrimPrefix(name, "/")
 	name = strings.Replace(name, "/", ".", -1)
 	return name
@@ -0,0 +1,64 @@
+package tables
+
+/*
+ * Diagnostics collect every error and warning found while
+ * parsing a table, so a table can be fixed in one pass
+ * rather than one run per broken line
+ */
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Severity of a Diagnostic
+const (
+	SEVERITY_ERROR   = "error"
+	SEVERITY_WARNING = "warning"
+)
+
+type Diagnostic struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`   // 1 based
+	Column   int    `json:"column"` // 1 based
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// formatted like a compiler message so editors can jump to it
+// e.g. Tables/Sample.tab:12:1: error: no delimiter
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s: %s", d.File, d.Line, d.Column, d.Severity, d.Message)
+}
+
+type Diagnostics []Diagnostic
+
+// Diagnostics is an error, so Parse can return all of them
+func (ds Diagnostics) Error() string {
+	var lines []string
+	for _, d := range ds {
+		lines = append(lines, d.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// HasErrors is true when any diagnostic is an error,
+// warnings alone do not stop a table being used
+func (ds Diagnostics) HasErrors() bool {
+	for _, d := range ds {
+		if d.Severity == SEVERITY_ERROR {
+			return true
+		}
+	}
+	return false
+}
+
+func (ds *Diagnostics) add(severity, file string, line, col int, format string, args ...interface{}) {
+	*ds = append(*ds, Diagnostic{
+		File:     file,
+		Line:     line,
+		Column:   col,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
@@ -0,0 +1,281 @@
+package tables
+
+/*
+ * Dice rolling for the Dice builtin
+ *
+ * The notation follows go-roll's FromString; NdM followed by
+ * any of the operations Kh/Kl (keep high/low), Kn (keep numbers),
+ * Dh/Dl (drop high/low), Dn (drop numbers) and X (explode).
+ * e.g. 3d6, 4d6Dl1, 4d10Kh3Dl1, 3d6X6
+ *
+ * All dice are rolled from the random source of the session,
+ * see Table.random, so a seeded generation can be replayed.
+ */
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"rtbl/rng"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	lexDice  = regexp.MustCompile(`^\d*d\d+`)
+	lexKeep  = regexp.MustCompile(`^K(l|h)\d+`)
+	lexKeepN = regexp.MustCompile(`^Kn[\d,]+`)
+	lexDrop  = regexp.MustCompile(`^D(l|h)\d+`)
+	lexDropN = regexp.MustCompile(`^Dn[\d,]+`)
+	lexExp   = regexp.MustCompile(`^X[\d,]+`)
+	lexNum   = regexp.MustCompile(`\d+`)
+)
+
+// roll a single die with the given number of sides
+func rollDie(r rng.Source, sides int) int {
+	return r.Intn(sides) + 1
+}
+
+// numbers listed after Kn, Dn or X, e.g. Dn1,2
+func parseNumList(s string) []int {
+	var nums []int
+	for _, tok := range lexNum.FindAllString(s, -1) {
+		n, _ := strconv.Atoi(tok)
+		nums = append(nums, n)
+	}
+	return nums
+}
+
+func containsInt(list []int, n int) bool {
+	for _, v := range list {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
+
+// rollDice rolls a dice string and returns the sum of the kept dice
+func rollDice(r rng.Source, s string) (int, error) {
+	s = strings.TrimSpace(s)
+	spec := lexDice.FindString(s)
+	if spec == "" {
+		return 0, fmt.Errorf("%s: first operation must be a dice string (3d6 etc)", s)
+	}
+	// the count may be left out, d20 is 1d20
+	n, sides := 1, 0
+	if spec[0] == 'd' {
+		fmt.Sscanf(spec, "d%d", &sides)
+	} else {
+		fmt.Sscanf(spec, "%dd%d", &n, &sides)
+	}
+	if n == 0 || sides < 2 {
+		return 0, fmt.Errorf("non-euclidean die: %s", spec)
+	}
+	rolls := make([]int, n)
+	for j := range rolls {
+		rolls[j] = rollDie(r, sides)
+	}
+
+	s = s[len(spec):]
+	for len(s) > 0 {
+		var op string
+		switch {
+		case lexKeepN.MatchString(s):
+			op = lexKeepN.FindString(s)
+			keep := parseNumList(op[2:])
+			var kept []int
+			for _, r := range rolls {
+				if containsInt(keep, r) {
+					kept = append(kept, r)
+				}
+			}
+			rolls = kept
+		case lexKeep.MatchString(s):
+			op = lexKeep.FindString(s)
+			k, _ := strconv.Atoi(op[2:])
+			if k < 1 {
+				return 0, fmt.Errorf("cannot keep a negative quantity of dice: %s", op)
+			}
+			if k < len(rolls) {
+				sort.Ints(rolls)
+				if op[1] == 'h' {
+					rolls = rolls[len(rolls)-k:]
+				} else {
+					rolls = rolls[:k]
+				}
+			}
+		case lexDropN.MatchString(s):
+			op = lexDropN.FindString(s)
+			drop := parseNumList(op[2:])
+			var kept []int
+			for _, r := range rolls {
+				if !containsInt(drop, r) {
+					kept = append(kept, r)
+				}
+			}
+			rolls = kept
+		case lexDrop.MatchString(s):
+			op = lexDrop.FindString(s)
+			d, _ := strconv.Atoi(op[2:])
+			if d > len(rolls) {
+				return 0, fmt.Errorf("cannot drop more dice than rolled: %s", op)
+			}
+			if d > 0 {
+				sort.Ints(rolls)
+				if op[1] == 'h' {
+					rolls = rolls[:len(rolls)-d]
+				} else {
+					rolls = rolls[d:]
+				}
+			}
+		case lexExp.MatchString(s):
+			op = lexExp.FindString(s)
+			explode := parseNumList(op[1:])
+			if len(explode) >= sides {
+				return 0, fmt.Errorf("numbers exploded equals or exceeds faces of die")
+			}
+			for j := 0; j < len(rolls); j++ {
+				if containsInt(explode, rolls[j]) {
+					rolls = append(rolls, rollDie(r, sides))
+				}
+			}
+		default:
+			return 0, fmt.Errorf("unparsed characters: %s", s)
+		}
+		s = s[len(op):]
+	}
+
+	sum := 0
+	for _, r := range rolls {
+		sum += r
+	}
+	return sum, nil
+}
+
+// the roll declared by a colon group, e.g. :Reaction=2d6,
+// NdM with an optional +K or -K. d66 and d666, with no count,
+// roll a d6 for each digit, e.g. 11 to 66
+type groupDice struct {
+	spec   string
+	count  int // dice summed, or digits of a d66
+	sides  int
+	mod    int
+	digits bool
+}
+
+var lexGroupDice = regexp.MustCompile(`^(\d*)d(\d+)([+-]\d+)?$`)
+
+func isGroupDice(spec string) bool {
+	return lexGroupDice.MatchString(spec)
+}
+
+func parseGroupDice(spec string) (*groupDice, error) {
+	m := lexGroupDice.FindStringSubmatch(spec)
+	if m == nil {
+		return nil, fmt.Errorf("%s is not a group roll, e.g. 2d6, 3d6+1 or d66", spec)
+	}
+	d := &groupDice{spec: spec, count: 1}
+	if m[1] == "" && len(m[2]) > 1 && strings.Trim(m[2], "6") == "" {
+		d.digits = true
+		d.count = len(m[2])
+		d.sides = 6
+	} else {
+		if m[1] != "" {
+			d.count, _ = strconv.Atoi(m[1])
+		}
+		d.sides, _ = strconv.Atoi(m[2])
+	}
+	if m[3] != "" {
+		d.mod, _ = strconv.Atoi(m[3])
+	}
+	if d.count < 1 {
+		return nil, fmt.Errorf("%s: a group must roll at least one die", spec)
+	}
+	if d.sides < 2 {
+		return nil, fmt.Errorf("non-euclidean die: %s", spec)
+	}
+	// every way of rolling the dice is counted, see ways
+	outcomes := 1
+	for j := 0; j < d.count; j++ {
+		if outcomes > math.MaxInt32/d.sides {
+			return nil, fmt.Errorf("%s: too many dice for a group roll", spec)
+		}
+		outcomes *= d.sides
+	}
+	if d.digits && d.count > 4 {
+		return nil, fmt.Errorf("%s: a digit roll may have at most 4 digits", spec)
+	}
+	return d, nil
+}
+
+func (d *groupDice) roll(r rng.Source) int {
+	n := 0
+	for j := 0; j < d.count; j++ {
+		if d.digits {
+			n = n*10 + rollDie(r, d.sides)
+		} else {
+			n += rollDie(r, d.sides)
+		}
+	}
+	return n + d.mod
+}
+
+func (d *groupDice) min() int {
+	if d.digits {
+		return d.digitsOf(1) + d.mod
+	}
+	return d.count + d.mod
+}
+
+func (d *groupDice) max() int {
+	if d.digits {
+		return d.digitsOf(d.sides) + d.mod
+	}
+	return d.count*d.sides + d.mod
+}
+
+// a number with every digit n, e.g. 66
+func (d *groupDice) digitsOf(n int) int {
+	v := 0
+	for j := 0; j < d.count; j++ {
+		v = v*10 + n
+	}
+	return v
+}
+
+// ways returns how many rolls of the dice make each result,
+// from min to max, 0 for a result that can not be rolled
+func (d *groupDice) ways() []int {
+	lo := d.min()
+	ways := make([]int, d.max()-lo+1)
+	if d.digits {
+		for n := range ways {
+			v := n + lo - d.mod
+			ok := true
+			for ; v > 0; v /= 10 {
+				if digit := v % 10; digit < 1 || digit > d.sides {
+					ok = false
+				}
+			}
+			if ok {
+				ways[n] = 1
+			}
+		}
+		return ways
+	}
+	// sums of one more die at a time, sums[s] is the ways to make s
+	sums := []int{1}
+	for j := 0; j < d.count; j++ {
+		next := make([]int, len(sums)+d.sides)
+		for s, w := range sums {
+			for face := 1; face <= d.sides; face++ {
+				next[s+face] += w
+			}
+		}
+		sums = next
+	}
+	copy(ways, sums[d.count:])
+	return ways
+}
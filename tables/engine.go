@@ -0,0 +1,323 @@
+package tables
+
+/*
+ * Engine is how rtbl is used as a library. Each Engine has its
+ * own tables and random source, nothing is shared with another
+ * Engine or with the TableRegistry used by the rtbl command.
+ * An Engine may be used by many goroutines at once.
+ *
+ *   e := tables.NewEngine(tables.WithRoot("Tables"))
+ *   if err := e.Load(); err != nil { ... }
+ *   res, err := e.Roll(ctx, "Monster.Start", map[string]string{"Terrain": "Swamp"})
+ */
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"os"
+	"rtbl/rng"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var ErrNotLoaded = errors.New("no tables loaded, call Load first")
+
+// Prompter is asked for the value of every table parameter
+// not passed to Roll, and by InputList, without one the default
+// is used
+type Prompter interface {
+	Prompt(ctx context.Context, table string, p *Param) (string, error)
+}
+
+// Result of a roll, or an evaluation
+type Result struct {
+	Table string     `json:"table"`
+	Group string     `json:"group"`
+	Seed  int64      `json:"seed"` // RollSeed with this seed makes the same result
+	HTML  string     `json:"html"`
+	Trace *TraceNode `json:"trace,omitempty"` // only WithTrace
+}
+
+type TableInfo struct {
+	Name     string `json:"name"`     // full dotted name, lower case
+	Category string `json:"category"` // the name without the last part
+	Path     string `json:"path"`     // within the root, or file system, it was loaded from
+}
+
+type Option func(*Engine)
+
+// WithRoot loads the tables under dir, e.g. Names/Greek.tab is the
+// table Names.Greek, more than one root may be given, the first
+// with a table of a name is the one used
+func WithRoot(dir string) Option {
+	return func(e *Engine) {
+		e.sources = append(e.sources, os.DirFS(dir))
+	}
+}
+
+// WithFS loads the tables in fsys, e.g. an embed.FS
+func WithFS(fsys fs.FS) Option {
+	return func(e *Engine) {
+		e.sources = append(e.sources, fsys)
+	}
+}
+
+// WithRand draws the seed of every roll from src
+func WithRand(src rng.Source) Option {
+	return func(e *Engine) {
+		e.rand = src
+	}
+}
+
+// WithSeed makes every roll repeatable, from the first
+func WithSeed(seed int64) Option {
+	return WithRand(rand.New(rand.NewSource(seed)))
+}
+
+func WithPrompter(p Prompter) Option {
+	return func(e *Engine) {
+		e.prompter = p
+	}
+}
+
+// WithResultSink gives every result to f as it is made,
+// by the goroutine that rolled it
+func WithResultSink(f func(Result)) Option {
+	return func(e *Engine) {
+		e.onResult = f
+	}
+}
+
+// WithDiagnosticSink gives f the warnings, and errors, of each
+// table when it is first parsed, by the goroutine that parsed it
+func WithDiagnosticSink(f func(Diagnostic)) Option {
+	return func(e *Engine) {
+		e.onDiagnostic = f
+	}
+}
+
+// WithTrace records how each result was rolled in Result.Trace
+func WithTrace() Option {
+	return func(e *Engine) {
+		e.trace = true
+	}
+}
+
+// WithMaxLoops sets the most loops a While may make,
+// the default is MAX_LOOPS
+func WithMaxLoops(n int) Option {
+	return func(e *Engine) {
+		e.maxLoops = n
+	}
+}
+
+type Engine struct {
+	sources      []fs.FS
+	prompter     Prompter
+	onResult     func(Result)
+	onDiagnostic func(Diagnostic)
+	trace        bool
+	maxLoops     int
+
+	mu       sync.Mutex // guards rand and registry
+	rand     rng.Source
+	registry *Registry
+}
+
+func NewEngine(opts ...Option) *Engine {
+	e := &Engine{}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if e.rand == nil {
+		e.rand = rand.New(rand.NewSource(rng.NewSeed()))
+	}
+	return e
+}
+
+// Load finds every table in the roots and file systems, tables
+// are parsed when first rolled. Load again to see changed files
+func (e *Engine) Load() error {
+	r := newRegistry()
+	r.diagnose = e.onDiagnostic
+	for _, fsys := range e.sources {
+		paths, err := findTablesFS(fsys)
+		if err != nil {
+			return err
+		}
+		r.add(fsys, ".", paths)
+	}
+	if len(r.tables) == 0 {
+		return fmt.Errorf("No tables found")
+	}
+	e.mu.Lock()
+	e.registry = r
+	e.mu.Unlock()
+	return nil
+}
+
+// the registry of the last Load
+func (e *Engine) loaded() (*Registry, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.registry == nil {
+		return nil, ErrNotLoaded
+	}
+	return e.registry, nil
+}
+
+// the seed of the next roll
+func (e *Engine) nextSeed() int64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.rand.Int63()
+}
+
+// Tables returns every table loaded, sorted by name
+func (e *Engine) Tables() []TableInfo {
+	r, err := e.loaded()
+	if err != nil {
+		return nil
+	}
+	var infos []TableInfo
+	for _, name := range r.Names() {
+		lt := r.tables[name]
+		category := ""
+		if idx := strings.LastIndex(name, "."); idx != -1 {
+			category = name[:idx]
+		}
+		infos = append(infos, TableInfo{Name: name, Category: category, Path: lt.path})
+	}
+	return infos
+}
+
+// Table parses, and returns, the table named, for its groups
+// and parameters. The table must not be changed
+func (e *Engine) Table(name string) (*Table, error) {
+	r, err := e.loaded()
+	if err != nil {
+		return nil, err
+	}
+	return r.Parse(name)
+}
+
+// Roll generates a result from a reference such as Table,
+// Table.Group or Category.Table.Group, params are the table
+// parameters by name. Each roll starts from a new Session.
+// When rolling makes errors the result is returned with
+// EvalErrors, the result holds the --ERROR messages
+func (e *Engine) Roll(ctx context.Context, ref string, params map[string]string) (Result, error) {
+	return e.RollSeed(ctx, ref, params, e.nextSeed())
+}
+
+// RollSeed is Roll with the seed of a previous Result
+func (e *Engine) RollSeed(ctx context.Context, ref string, params map[string]string, seed int64) (Result, error) {
+	r, err := e.loaded()
+	if err != nil {
+		return Result{}, err
+	}
+	if err := ctx.Err(); err != nil {
+		return Result{}, err
+	}
+	parsed, group, err := r.Resolve(ref)
+	if err != nil {
+		return Result{}, err
+	}
+	s := e.newSession(ctx, seed)
+	t := s.Table(parsed)
+
+	// parameters are bound by name, in the order declared
+	var args []string
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	given := make(map[*Param]bool) // names are matched in any case
+	for _, name := range names {
+		p, ok := t.GetParam(name)
+		if !ok {
+			return Result{}, fmt.Errorf("table %s has no parameter named %s", t.Name, name)
+		}
+		given[p] = true
+		args = append(args, name+"="+params[name])
+	}
+	if e.prompter != nil {
+		for _, p := range t.Params {
+			if given[p] {
+				continue
+			}
+			v, err := e.prompter.Prompt(ctx, t.Name, p)
+			if err != nil {
+				return Result{}, err
+			}
+			args = append(args, p.Name+"="+v)
+		}
+	}
+	if err := t.BindArgs(args); err != nil {
+		return Result{}, err
+	}
+
+	res := Result{Table: t.Name, Group: group, Seed: seed}
+	if e.trace {
+		res.HTML, res.Trace = t.RollTrace(group)
+	} else {
+		res.HTML = t.Roll(group)
+	}
+	res.HTML = t.Header + res.HTML + t.Footer
+	return e.finish(ctx, s, res)
+}
+
+// Evaluate generates a result from text written like a table
+// entry, e.g. "A [Color] {Cap~[Monster]}"
+func (e *Engine) Evaluate(ctx context.Context, text string) (Result, error) {
+	r, err := e.loaded()
+	if err != nil {
+		return Result{}, err
+	}
+	if err := ctx.Err(); err != nil {
+		return Result{}, err
+	}
+	seed := e.nextSeed()
+	s := e.newSession(ctx, seed)
+	parsed := NewTable("evaluate")
+	parsed.registry = r
+	t := s.Table(parsed)
+
+	res := Result{Table: t.Name, Seed: seed}
+	if e.trace {
+		s.StartTrace(t.Name, "")
+		res.HTML = t.Evaluate(text)
+		res.Trace = s.StopTrace()
+		res.Trace.Result = res.HTML
+	} else {
+		res.HTML = t.Evaluate(text)
+	}
+	return e.finish(ctx, s, res)
+}
+
+func (e *Engine) newSession(ctx context.Context, seed int64) *Session {
+	s := NewSession()
+	s.Seed(seed)
+	s.SetMaxLoops(e.maxLoops)
+	s.ctx = ctx
+	s.SetPrompter(e.prompter)
+	return s
+}
+
+func (e *Engine) finish(ctx context.Context, s *Session, res Result) (Result, error) {
+	if err := ctx.Err(); err != nil {
+		return Result{}, err
+	}
+	if e.onResult != nil {
+		e.onResult(res)
+	}
+	if errs := s.Errors(); len(errs) > 0 {
+		return res, errs
+	}
+	return res, nil
+}
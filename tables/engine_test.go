@@ -0,0 +1,223 @@
+package tables
+
+/*
+ * Test the library API, see engine.go
+ */
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+var engineFS = fstest.MapFS{
+	"Monster.tab": {Data: []byte(
+		"@Terrain,Forest,Which terrain,Forest,Swamp\n" +
+			":Start\n1-6,[Beast] of the %Terrain%\n" +
+			";Beast\n1,Wolf\n1,Bear\n1,Toad\n1,Crow\n")},
+	"Names/Greek.tab":  {Data: []byte(":Start\n1-4,[Name]\n;Name\n1,Alexios\n1,Daphne\n1,Nikos\n")},
+	"Names/Broken.tab": {Data: []byte(":Start\n1,[Nowhere]\n/Stylesheet x\n")},
+}
+
+func newTestEngine(t *testing.T, opts ...Option) *Engine {
+	e := NewEngine(append([]Option{WithFS(engineFS)}, opts...)...)
+	if err := e.Load(); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestEngineTables(t *testing.T) {
+	e := NewEngine(WithFS(engineFS))
+	if _, err := e.Roll(context.Background(), "Monster", nil); err != ErrNotLoaded {
+		t.Logf("wanted ErrNotLoaded, have %v", err)
+		t.Fail()
+	}
+	e = newTestEngine(t)
+	infos := e.Tables()
+	if len(infos) != 3 || infos[0].Name != "monster" || infos[1].Category != "names" ||
+		infos[2].Path != "Names/Greek.tab" {
+		t.Logf("wrong tables %+v", infos)
+		t.Fail()
+	}
+	tbl, err := e.Table("Greek")
+	if err != nil || len(tbl.GroupNames()) != 2 {
+		t.Logf("Greek table %v", err)
+		t.Fail()
+	}
+}
+
+// a table in more than one file system is the first one, by any name
+func TestEngineDuplicateTables(t *testing.T) {
+	ctx := context.Background()
+	var diags []Diagnostic
+	other := fstest.MapFS{"Names/Greek.tab": {Data: []byte(":Start\n1,Zeus\n")}}
+	e := newTestEngine(t, WithFS(other), WithDiagnosticSink(func(d Diagnostic) { diags = append(diags, d) }))
+	if infos := e.Tables(); len(infos) != 3 {
+		t.Logf("wanted 3 tables, have %+v", infos)
+		t.Fail()
+	}
+	for _, name := range []string{"Names.Greek", "Greek"} {
+		res, err := e.Roll(ctx, name, nil)
+		if err != nil || res.HTML == "Zeus" {
+			t.Logf("%s rolled the later table %q %v", name, res.HTML, err)
+			t.Fail()
+		}
+	}
+	if len(diags) != 1 || diags[0].Severity != SEVERITY_WARNING || !strings.Contains(diags[0].Message, "names.greek") {
+		t.Logf("duplicate not reported %v", diags)
+		t.Fail()
+	}
+}
+
+func TestEngineRoll(t *testing.T) {
+	ctx := context.Background()
+	var sunk []Result
+	e := newTestEngine(t, WithSeed(7), WithResultSink(func(r Result) { sunk = append(sunk, r) }))
+
+	res, err := e.Roll(ctx, "Monster", map[string]string{"Terrain": "swamp"})
+	if err != nil || res.Table != "monster" || res.Group != "Start" {
+		t.Fatalf("roll failed %+v %v", res, err)
+	}
+	if !strings.HasSuffix(res.HTML, " of the Swamp") {
+		t.Logf("parameter not bound %q", res.HTML)
+		t.Fail()
+	}
+	again, _ := e.RollSeed(ctx, "Monster", map[string]string{"Terrain": "Swamp"}, res.Seed)
+	if again.HTML != res.HTML {
+		t.Logf("seed %d did not replay %q, have %q", res.Seed, res.HTML, again.HTML)
+		t.Fail()
+	}
+	if len(sunk) != 2 || sunk[1].HTML != res.HTML {
+		t.Logf("results not given to the sink %+v", sunk)
+		t.Fail()
+	}
+
+	// engines with the same seed roll the same results
+	other := newTestEngine(t, WithSeed(7))
+	res2, _ := other.Roll(ctx, "Monster", map[string]string{"Terrain": "Swamp"})
+	if res2.HTML != res.HTML || res2.Seed != res.Seed {
+		t.Logf("same seed rolled %q and %q", res.HTML, res2.HTML)
+		t.Fail()
+	}
+
+	if _, err := e.Roll(ctx, "Monster", map[string]string{"Level": "3"}); err == nil {
+		t.Log("no error for a parameter that is not declared")
+		t.Fail()
+	}
+	if _, err := e.Roll(ctx, "Dragon", nil); err == nil {
+		t.Log("no error for a missing table")
+		t.Fail()
+	}
+}
+
+type fixedPrompter string
+
+func (p fixedPrompter) Prompt(ctx context.Context, table string, param *Param) (string, error) {
+	return string(p), nil
+}
+
+func TestEnginePrompter(t *testing.T) {
+	e := newTestEngine(t, WithPrompter(fixedPrompter("2")))
+	res, err := e.Evaluate(context.Background(), "{Cap~[Monster.Start]}")
+	if err != nil || !strings.HasSuffix(res.HTML, "OF THE FOREST") {
+		t.Logf("nested roll should not prompt %q %v", res.HTML, err)
+		t.Fail()
+	}
+	res, err = e.Roll(context.Background(), "Monster", nil)
+	if err != nil || !strings.HasSuffix(res.HTML, "of the Swamp") {
+		t.Logf("prompted parameter not bound %q %v", res.HTML, err)
+		t.Fail()
+	}
+	// a parameter given in any case is not prompted for
+	e1 := newTestEngine(t, WithPrompter(fixedPrompter("1")))
+	res, err = e1.Roll(context.Background(), "Monster", map[string]string{"terrain": "Swamp"})
+	if err != nil || !strings.HasSuffix(res.HTML, "of the Swamp") {
+		t.Logf("given parameter was prompted for %q %v", res.HTML, err)
+		t.Fail()
+	}
+	res, err = e.Evaluate(context.Background(), "{InputList~0,Which weapon,sword,axe}")
+	if err != nil || res.HTML != "axe" {
+		t.Logf("InputList wanted the prompted axe, have %q %v", res.HTML, err)
+		t.Fail()
+	}
+}
+
+func TestEngineErrors(t *testing.T) {
+	var diags []Diagnostic
+	e := newTestEngine(t, WithTrace(), WithDiagnosticSink(func(d Diagnostic) { diags = append(diags, d) }))
+	// the TableRegistry used by the command is never used
+	saved := TableRegistry
+	TableRegistry = nil
+	defer func() { TableRegistry = saved }()
+
+	res, err := e.Roll(context.Background(), "Names.Broken", nil)
+	var errs EvalErrors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Ref != "[Nowhere]" {
+		t.Fatalf("wanted an EvalError for [Nowhere], have %v", err)
+	}
+	if res.HTML == "" || res.Trace == nil {
+		t.Logf("result, and trace, missing with the errors %+v", res)
+		t.Fail()
+	}
+	if len(diags) != 1 || diags[0].Severity != SEVERITY_WARNING {
+		t.Logf("wrong diagnostics %v", diags)
+		t.Fail()
+	}
+
+	res, err = e.Evaluate(context.Background(), "[Greek.Name] and [Monster.Beast]")
+	if err != nil || res.Trace == nil || len(res.Trace.Children) != 2 {
+		t.Logf("evaluate failed %q %v", res.HTML, err)
+		t.Fail()
+	}
+
+	e = newTestEngine(t, WithMaxLoops(2))
+	res, err = e.Evaluate(context.Background(), "{While~1=1,x}")
+	if !errors.As(err, &errs) || !strings.Contains(res.HTML, "still true after 2 loops") {
+		t.Logf("While was not stopped after 2 loops %q %v", res.HTML, err)
+		t.Fail()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := e.Roll(ctx, "Monster", nil); err != context.Canceled {
+		t.Logf("wanted context.Canceled, have %v", err)
+		t.Fail()
+	}
+}
+
+// run with -race, one engine rolled on by many goroutines
+func TestEngineConcurrent(t *testing.T) {
+	ctx := context.Background()
+	e := newTestEngine(t, WithResultSink(func(Result) {}))
+	want, err := e.RollSeed(ctx, "Monster", nil, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for j := 0; j < 16; j++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := 0; k < 20; k++ {
+				res, err := e.Roll(ctx, "Monster", map[string]string{"Terrain": "Swamp"})
+				if err != nil || !strings.HasSuffix(res.HTML, "of the Swamp") {
+					t.Errorf("roll failed %q %v", res.HTML, err)
+					return
+				}
+				if res, _ := e.RollSeed(ctx, "Monster", nil, 42); res.HTML != want.HTML {
+					t.Errorf("seed 42 rolled %q, alone it rolls %q", res.HTML, want.HTML)
+					return
+				}
+				if _, err := e.Evaluate(ctx, "[Greek] [Monster.Beast]"); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,809 @@
+package tables
+
+/*
+ * Expressions of If, IIf, While, Calc and modified group rolls,
+ * e.g. {If~%Level% >= 3 and %Class% = Wizard?...}
+ *
+ *   12, 1.5, "text"   numbers and quoted text
+ *   %Level%           a variable, %Gold:,% formatted
+ *   {Dice~1d6} [Gem]  a builtin call or roll, evaluated as it is used
+ *   Level             a variable, or else the word as text
+ *   Red Dragon        words that are not variables are text, on the
+ *   Half-Orc          right of a comparison a word may hold hyphens
+ *   Abs(-3)           a function, see exprFuncs, or any builtin
+ *   ( )               grouping
+ *   ^                 power
+ *   - + not !         unary
+ *   * / \ mod         multiply, divide, integer divide, remainder
+ *   + -               add, subtract
+ *   = == <> != < > <= >=   compare numbers, or text ignoring case
+ *   ~ !~              text matches a wildcard pattern, * and ?
+ *   and or            logical, only what is needed is evaluated
+ *
+ * The expression is parsed as written, %Var%, {...} and [...] are
+ * values, so a variable holding Half-Orc or O'Brien is text rather
+ * than more of the expression.
+ */
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ExprError is an error in an expression, at Pos,
+// the byte offset in Expr
+type ExprError struct {
+	Expr string
+	Pos  int
+	Msg  string
+}
+
+func (e *ExprError) Error() string {
+	return fmt.Sprintf("%s at %d in %q", e.Msg, e.Pos+1, e.Expr)
+}
+
+type valueKind int
+
+const (
+	VALUE_NUMBER valueKind = iota
+	VALUE_TEXT
+	VALUE_BOOL
+)
+
+// the value of an expression, booleans are numbers 1 and 0
+// wherever a number is wanted
+type value struct {
+	kind valueKind
+	n    float64
+	s    string
+}
+
+func numberValue(n float64) value { return value{kind: VALUE_NUMBER, n: n} }
+func textValue(s string) value    { return value{kind: VALUE_TEXT, s: s} }
+
+func boolValue(b bool) value {
+	if b {
+		return value{kind: VALUE_BOOL, n: 1}
+	}
+	return value{kind: VALUE_BOOL}
+}
+
+// text that is a number is a number
+func parseValue(s string) value {
+	if f, ok := parseNumber(s); ok {
+		return numberValue(f)
+	}
+	return textValue(s)
+}
+
+// the number of text, NaN and Inf are text, as for NewVariable
+func parseNumber(s string) (float64, bool) {
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	return f, err == nil && !math.IsNaN(f) && !math.IsInf(f, 0)
+}
+
+func (v value) String() string {
+	switch v.kind {
+	case VALUE_NUMBER:
+		return formatNumber(v.n)
+	case VALUE_BOOL:
+		if v.n != 0 {
+			return "1"
+		}
+		return "0"
+	}
+	return v.s
+}
+
+func (v value) number() (float64, bool) {
+	if v.kind != VALUE_TEXT {
+		return v.n, true
+	}
+	return parseNumber(v.s)
+}
+
+// false is 0, empty text, "0" or "false"
+func (v value) truth() bool {
+	if v.kind != VALUE_TEXT {
+		return v.n != 0
+	}
+	if n, ok := v.number(); ok {
+		return n != 0
+	}
+	s := strings.TrimSpace(v.s)
+	return s != "" && !strings.EqualFold(s, "false")
+}
+
+type tokenKind int
+
+const (
+	TOKEN_EOF tokenKind = iota
+	TOKEN_NUMBER
+	TOKEN_TEXT // quoted text, or a wildcard pattern
+	TOKEN_WORD
+	TOKEN_VARIABLE // %Var%, the text is the name
+	TOKEN_MARKUP   // {...} or [...], the text as written
+	TOKEN_OP
+	TOKEN_LPAREN
+	TOKEN_RPAREN
+	TOKEN_COMMA
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// the operators, longest first
+var exprOps = []string{"==", "!=", "<>", "<=", ">=", "!~", "=", "<", ">", "~", "+", "-", "*", "/", "\\", "^", "!"}
+
+type exprKind int
+
+const (
+	EXPR_VALUE    exprKind = iota
+	EXPR_WORD              // a variable, or else text
+	EXPR_VARIABLE          // %Var%
+	EXPR_MARKUP            // {...} or [...], rendered when evaluated
+	EXPR_CALL
+	EXPR_UNARY
+	EXPR_BINARY
+)
+
+type expr struct {
+	kind exprKind
+	pos  int
+	op   string  // operator, or the name of a word, variable or function
+	val  value   // a value, or the format of a variable as text
+	args []*expr // operands, or the arguments of a function
+	body nodes   // EXPR_MARKUP compiled
+}
+
+type exprParser struct {
+	src string
+	pos int
+	tok token
+}
+
+func (p *exprParser) errorf(pos int, format string, args ...interface{}) error {
+	return &ExprError{Expr: p.src, Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+}
+
+// read the next token into p.tok
+func (p *exprParser) next() error {
+	p.skipSpace()
+	start := p.pos
+	if p.pos >= len(p.src) {
+		p.tok = token{kind: TOKEN_EOF, pos: start}
+		return nil
+	}
+	c := p.src[p.pos]
+	switch {
+	case c == '(':
+		p.pos++
+		p.tok = token{kind: TOKEN_LPAREN, text: "(", pos: start}
+	case c == ')':
+		p.pos++
+		p.tok = token{kind: TOKEN_RPAREN, text: ")", pos: start}
+	case c == ',':
+		p.pos++
+		p.tok = token{kind: TOKEN_COMMA, text: ",", pos: start}
+	case c == '"' || c == '\'':
+		end := strings.IndexByte(p.src[p.pos+1:], c)
+		if end == -1 {
+			return p.errorf(start, "text is not closed with %c", c)
+		}
+		p.pos += end + 2
+		p.tok = token{kind: TOKEN_TEXT, text: p.src[start+1 : p.pos-1], pos: start}
+	case c == '%':
+		end := strings.IndexByte(p.src[p.pos+1:], '%')
+		if end == -1 {
+			return p.errorf(start, "variable name is not closed with %%")
+		}
+		p.pos += end + 2
+		p.tok = token{kind: TOKEN_VARIABLE, text: p.src[start+1 : p.pos-1], pos: start}
+	case c == '{' || c == '[':
+		closer := "}"
+		if c == '[' {
+			closer = "]"
+		}
+		_, last := findEndDelim(p.src[p.pos+1:], string(c), closer)
+		if p.pos+1+last >= len(p.src) {
+			return p.errorf(start, "%c is not closed with %s", c, closer)
+		}
+		p.pos += last + 2
+		p.tok = token{kind: TOKEN_MARKUP, text: p.src[start:p.pos], pos: start}
+	case c >= '0' && c <= '9' || c == '.':
+		for p.pos < len(p.src) && (p.src[p.pos] >= '0' && p.src[p.pos] <= '9' || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		p.tok = token{kind: TOKEN_NUMBER, text: p.src[start:p.pos], pos: start}
+	case c == '_' || unicode.IsLetter(rune(c)):
+		for p.pos < len(p.src) && (p.src[p.pos] == '_' || unicode.IsLetter(rune(p.src[p.pos])) ||
+			unicode.IsDigit(rune(p.src[p.pos]))) {
+			p.pos++
+		}
+		p.tok = token{kind: TOKEN_WORD, text: p.src[start:p.pos], pos: start}
+	default:
+		for _, op := range exprOps {
+			if strings.HasPrefix(p.src[p.pos:], op) {
+				p.pos += len(op)
+				p.tok = token{kind: TOKEN_OP, text: op, pos: start}
+				return nil
+			}
+		}
+		return p.errorf(start, "unexpected %c", c)
+	}
+	return nil
+}
+
+// read a wildcard pattern, the right of ~ and !~, unquoted it
+// runs to the next space or ), or it is a value, e.g. %Var%
+func (p *exprParser) nextPattern() error {
+	p.skipSpace()
+	if p.pos < len(p.src) && strings.IndexByte("\"'%{[", p.src[p.pos]) != -1 {
+		return p.next()
+	}
+	start := p.pos
+	for p.pos < len(p.src) && p.src[p.pos] != ')' && !unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+	if start == p.pos {
+		return p.errorf(start, "no pattern to match")
+	}
+	p.tok = token{kind: TOKEN_TEXT, text: p.src[start:p.pos], pos: start}
+	return nil
+}
+
+// read the right of a comparison, a word may hold hyphens,
+// %Race% = Half-Orc compares with the text Half-Orc, while
+// Level-1 is still a subtraction
+func (p *exprParser) nextCompared() error {
+	p.skipSpace()
+	start := p.pos
+	if p.pos >= len(p.src) || p.src[p.pos] != '_' && !unicode.IsLetter(rune(p.src[p.pos])) {
+		return p.next()
+	}
+	isWord := func(c byte) bool {
+		return c == '_' || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c))
+	}
+	for p.pos < len(p.src) {
+		if isWord(p.src[p.pos]) {
+			p.pos++
+		} else if p.src[p.pos] == '-' && p.pos+1 < len(p.src) &&
+			(p.src[p.pos+1] == '_' || unicode.IsLetter(rune(p.src[p.pos+1]))) {
+			p.pos++
+		} else {
+			break
+		}
+	}
+	p.tok = token{kind: TOKEN_WORD, text: p.src[start:p.pos], pos: start}
+	return nil
+}
+
+// is the token the keyword, or operator, s
+func (p *exprParser) is(s string) bool {
+	switch p.tok.kind {
+	case TOKEN_OP:
+		return p.tok.text == s
+	case TOKEN_WORD:
+		return strings.EqualFold(p.tok.text, s)
+	}
+	return false
+}
+
+func isKeyword(s string) bool {
+	switch strings.ToLower(s) {
+	case "and", "or", "not", "mod":
+		return true
+	}
+	return false
+}
+
+// parse an expression
+func parseExpr(s string) (*expr, error) {
+	p := &exprParser{src: s}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if p.tok.kind == TOKEN_EOF {
+		return nil, p.errorf(0, "empty expression")
+	}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != TOKEN_EOF {
+		return nil, p.errorf(p.tok.pos, "unexpected %s", p.tok.text)
+	}
+	return e, nil
+}
+
+// parse the operands of binary operators of one precedence,
+// from left to right
+func (p *exprParser) parseBinary(ops []string, operand func() (*expr, error)) (*expr, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := ""
+		for _, o := range ops {
+			if p.is(o) {
+				op = strings.ToLower(o)
+				break
+			}
+		}
+		if op == "" {
+			return left, nil
+		}
+		pos := p.tok.pos
+		switch op {
+		case "~", "!~":
+			err = p.nextPattern()
+		case "=", "==", "!=", "<>", "<", ">", "<=", ">=":
+			err = p.nextCompared()
+		default:
+			err = p.next()
+		}
+		if err != nil {
+			return nil, err
+		}
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = &expr{kind: EXPR_BINARY, pos: pos, op: op, args: []*expr{left, right}}
+	}
+}
+
+func (p *exprParser) parseOr() (*expr, error) {
+	return p.parseBinary([]string{"or"}, p.parseAnd)
+}
+
+func (p *exprParser) parseAnd() (*expr, error) {
+	return p.parseBinary([]string{"and"}, p.parseNot)
+}
+
+func (p *exprParser) parseNot() (*expr, error) {
+	if p.is("not") {
+		pos := p.tok.pos
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		e, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &expr{kind: EXPR_UNARY, pos: pos, op: "not", args: []*expr{e}}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *exprParser) parseCompare() (*expr, error) {
+	return p.parseBinary([]string{"==", "!=", "<>", "<=", ">=", "!~", "=", "<", ">", "~"}, p.parseAdd)
+}
+
+func (p *exprParser) parseAdd() (*expr, error) {
+	return p.parseBinary([]string{"+", "-"}, p.parseMul)
+}
+
+func (p *exprParser) parseMul() (*expr, error) {
+	return p.parseBinary([]string{"*", "/", "\\", "mod"}, p.parseUnary)
+}
+
+func (p *exprParser) parseUnary() (*expr, error) {
+	if p.is("-") || p.is("+") || p.is("!") {
+		op, pos := p.tok.text, p.tok.pos
+		if op == "!" {
+			op = "not"
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &expr{kind: EXPR_UNARY, pos: pos, op: op, args: []*expr{e}}, nil
+	}
+	return p.parsePower()
+}
+
+// power is right associative, 2^3^2 is 2^9
+func (p *exprParser) parsePower() (*expr, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if !p.is("^") {
+		return left, nil
+	}
+	pos := p.tok.pos
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	right, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return &expr{kind: EXPR_BINARY, pos: pos, op: "^", args: []*expr{left, right}}, nil
+}
+
+func (p *exprParser) parsePrimary() (*expr, error) {
+	tok := p.tok
+	switch tok.kind {
+	case TOKEN_NUMBER:
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, p.errorf(tok.pos, "%s is not a number", tok.text)
+		}
+		return &expr{kind: EXPR_VALUE, pos: tok.pos, val: numberValue(f)}, p.next()
+	case TOKEN_TEXT:
+		return &expr{kind: EXPR_VALUE, pos: tok.pos, val: textValue(tok.text)}, p.next()
+	case TOKEN_VARIABLE:
+		name, format := tok.text, ""
+		if k := strings.IndexByte(name, ':'); k != -1 {
+			name, format = name[:k], name[k+1:]
+		}
+		return &expr{kind: EXPR_VARIABLE, pos: tok.pos, op: name, val: textValue(format)}, p.next()
+	case TOKEN_MARKUP:
+		return &expr{kind: EXPR_MARKUP, pos: tok.pos, op: tok.text, body: compile(tok.text)}, p.next()
+	case TOKEN_LPAREN:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != TOKEN_RPAREN {
+			return nil, p.errorf(p.tok.pos, "missing ) for the ( at %d", tok.pos+1)
+		}
+		return e, p.next()
+	case TOKEN_WORD:
+		if isKeyword(tok.text) {
+			return nil, p.errorf(tok.pos, "unexpected %s", tok.text)
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if p.tok.kind == TOKEN_LPAREN {
+			return p.parseCall(tok)
+		}
+		// words that follow each other are text, e.g. Red Dragon
+		words := []string{tok.text}
+		for p.tok.kind == TOKEN_WORD && !isKeyword(p.tok.text) {
+			words = append(words, p.tok.text)
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+		}
+		if len(words) > 1 {
+			return &expr{kind: EXPR_VALUE, pos: tok.pos, val: textValue(strings.Join(words, " "))}, nil
+		}
+		return &expr{kind: EXPR_WORD, pos: tok.pos, op: tok.text}, nil
+	case TOKEN_EOF:
+		return nil, p.errorf(tok.pos, "missing a value at the end")
+	}
+	return nil, p.errorf(tok.pos, "unexpected %s", tok.text)
+}
+
+// parse the arguments of a function, name(a, b)
+func (p *exprParser) parseCall(name token) (*expr, error) {
+	e := &expr{kind: EXPR_CALL, pos: name.pos, op: name.text}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	for p.tok.kind != TOKEN_RPAREN {
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		e.args = append(e.args, arg)
+		if p.tok.kind == TOKEN_COMMA {
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+		} else if p.tok.kind != TOKEN_RPAREN {
+			return nil, p.errorf(p.tok.pos, "missing ) for %s(", name.text)
+		}
+	}
+	return e, p.next()
+}
+
+// evaluate the expression, t gives the variables, and may be nil
+func (e *expr) eval(t *Table, src string) (value, error) {
+	errorf := func(pos int, format string, args ...interface{}) error {
+		return &ExprError{Expr: src, Pos: pos, Msg: fmt.Sprintf(format, args...)}
+	}
+	// the operand, j, as a number
+	number := func(j int) (float64, error) {
+		v, err := e.args[j].eval(t, src)
+		if err != nil {
+			return 0, err
+		}
+		n, ok := v.number()
+		if !ok {
+			return 0, errorf(e.args[j].pos, "%s is not a number", v)
+		}
+		return n, nil
+	}
+
+	switch e.kind {
+	case EXPR_VALUE:
+		return e.val, nil
+	case EXPR_WORD:
+		if t != nil {
+			if v, ok := t.GetVariable(e.op); ok {
+				t.traceEnd(t.traceBegin(&TraceNode{Kind: TRACE_VARIABLE, Name: e.op}), v, nil)
+				return parseValue(v), nil
+			}
+		}
+		if strings.EqualFold(e.op, "true") {
+			return boolValue(true), nil
+		} else if strings.EqualFold(e.op, "false") {
+			return boolValue(false), nil
+		}
+		return textValue(e.op), nil
+	case EXPR_VARIABLE:
+		if t == nil {
+			return value{}, errorf(e.pos, "%%%s%% does not exist", e.op)
+		}
+		node := t.traceBegin(&TraceNode{Kind: TRACE_VARIABLE, Name: e.op})
+		v, ok := t.GetVariable(e.op) // a macro traces under node
+		if !ok {
+			err := errorf(e.pos, "%%%s%% does not exist", e.op)
+			t.traceEnd(node, "", err)
+			return value{}, err
+		}
+		if e.val.s != "" {
+			var err error
+			if v, err = formatVariable(v, e.val.s); err != nil {
+				err = errorf(e.pos, "%s", err)
+				t.traceEnd(node, "", err)
+				return value{}, err
+			}
+		}
+		t.traceEnd(node, v, nil)
+		return parseValue(v), nil
+	case EXPR_MARKUP:
+		if t == nil {
+			return value{}, errorf(e.pos, "%s needs a table", e.op)
+		}
+		return parseValue(t.inSession().render(e.body)), nil
+	case EXPR_CALL:
+		args := make([]value, len(e.args))
+		for j, a := range e.args {
+			v, err := a.eval(t, src)
+			if err != nil {
+				return value{}, err
+			}
+			args[j] = v
+		}
+		v, err := callExprFunc(t, e.op, args)
+		if err != nil {
+			return value{}, errorf(e.pos, "%s", err)
+		}
+		return v, nil
+	case EXPR_UNARY:
+		if e.op == "not" {
+			v, err := e.args[0].eval(t, src)
+			if err != nil {
+				return value{}, err
+			}
+			return boolValue(!v.truth()), nil
+		}
+		n, err := number(0)
+		if err != nil {
+			return value{}, err
+		}
+		if e.op == "-" {
+			n = -n
+		}
+		return numberValue(n), nil
+	}
+
+	// binary operators
+	switch e.op {
+	case "and", "or":
+		v, err := e.args[0].eval(t, src)
+		if err != nil {
+			return value{}, err
+		}
+		if v.truth() == (e.op == "or") { // the right is not needed
+			return boolValue(v.truth()), nil
+		}
+		v, err = e.args[1].eval(t, src)
+		if err != nil {
+			return value{}, err
+		}
+		return boolValue(v.truth()), nil
+	case "=", "==", "!=", "<>", "<", ">", "<=", ">=", "~", "!~":
+		a, err := e.args[0].eval(t, src)
+		if err != nil {
+			return value{}, err
+		}
+		b, err := e.args[1].eval(t, src)
+		if err != nil {
+			return value{}, err
+		}
+		return boolValue(compareValues(e.op, a, b)), nil
+	}
+	a, err := number(0)
+	if err != nil {
+		return value{}, err
+	}
+	b, err := number(1)
+	if err != nil {
+		return value{}, err
+	}
+	switch e.op {
+	case "+":
+		return numberValue(a + b), nil
+	case "-":
+		return numberValue(a - b), nil
+	case "*":
+		return numberValue(a * b), nil
+	case "^":
+		return numberValue(math.Pow(a, b)), nil
+	}
+	if b == 0 {
+		return value{}, errorf(e.pos, "division by zero")
+	}
+	switch e.op {
+	case "/":
+		return numberValue(a / b), nil
+	case "\\":
+		return numberValue(math.Trunc(a / b)), nil
+	case "mod":
+		return numberValue(math.Mod(a, b)), nil
+	}
+	return value{}, errorf(e.pos, "unknown operator %s", e.op)
+}
+
+// compare numbers as numbers, anything else as text ignoring case
+func compareValues(op string, a, b value) bool {
+	switch op {
+	case "~":
+		return wildcardMatch(b.String(), a.String())
+	case "!~":
+		return !wildcardMatch(b.String(), a.String())
+	}
+	c := 0
+	an, aok := a.number()
+	bn, bok := b.number()
+	if aok && bok {
+		if an < bn {
+			c = -1
+		} else if an > bn {
+			c = 1
+		}
+	} else {
+		c = strings.Compare(strings.ToLower(a.String()), strings.ToLower(b.String()))
+	}
+	switch op {
+	case "=", "==":
+		return c == 0
+	case "!=", "<>":
+		return c != 0
+	case "<":
+		return c < 0
+	case ">":
+		return c > 0
+	case "<=":
+		return c <= 0
+	}
+	return c >= 0 // >=
+}
+
+// does s match pattern, ignoring case, * is any text, ? any one letter
+func wildcardMatch(pattern, s string) bool {
+	p, r := []rune(strings.ToLower(pattern)), []rune(strings.ToLower(s))
+	// the position after the last *, and where it was tried in s
+	star, mark := -1, 0
+	j, k := 0, 0
+	for k < len(r) {
+		switch {
+		case j < len(p) && (p[j] == '?' || p[j] == r[k]):
+			j++
+			k++
+		case j < len(p) && p[j] == '*':
+			star, mark = j+1, k
+			j++
+		case star != -1: // the * takes one more letter
+			mark++
+			j, k = star, mark
+		default:
+			return false
+		}
+	}
+	for j < len(p) && p[j] == '*' {
+		j++
+	}
+	return j == len(p)
+}
+
+type exprFunc struct {
+	min, max int // number of arguments
+	f        func([]float64) float64
+}
+
+// functions of numbers, any other function is a builtin
+// called with its arguments joined by commas
+var exprFuncs = map[string]exprFunc{
+	"abs":   {1, 1, func(a []float64) float64 { return math.Abs(a[0]) }},
+	"ceil":  {1, 1, func(a []float64) float64 { return math.Ceil(a[0]) }},
+	"floor": {1, 1, func(a []float64) float64 { return math.Floor(a[0]) }},
+	"int":   {1, 1, func(a []float64) float64 { return math.Trunc(a[0]) }},
+	"trunc": {1, 1, func(a []float64) float64 { return math.Trunc(a[0]) }},
+	"sqrt":  {1, 1, func(a []float64) float64 { return math.Sqrt(a[0]) }},
+	"round": {1, 2, func(a []float64) float64 {
+		if len(a) == 1 {
+			return math.Round(a[0])
+		}
+		ratio := math.Pow(10, a[1])
+		return math.Round(a[0]*ratio) / ratio
+	}},
+	"min": {1, -1, func(a []float64) float64 {
+		m := a[0]
+		for _, n := range a[1:] {
+			m = math.Min(m, n)
+		}
+		return m
+	}},
+	"max": {1, -1, func(a []float64) float64 {
+		m := a[0]
+		for _, n := range a[1:] {
+			m = math.Max(m, n)
+		}
+		return m
+	}},
+}
+
+func callExprFunc(t *Table, name string, args []value) (value, error) {
+	if f, ok := exprFuncs[strings.ToLower(name)]; ok {
+		if len(args) < f.min || f.max != -1 && len(args) > f.max {
+			return value{}, fmt.Errorf("wrong number of arguments to %s", name)
+		}
+		nums := make([]float64, len(args))
+		for j, a := range args {
+			n, ok := a.number()
+			if !ok {
+				return value{}, fmt.Errorf("%s(%s) is not a number", name, a)
+			}
+			nums[j] = n
+		}
+		return numberValue(f.f(nums)), nil
+	}
+	if !isBuiltin(name) {
+		return value{}, fmt.Errorf("unknown function %s", name)
+	}
+	strs := make([]string, len(args))
+	for j, a := range args {
+		strs[j] = a.String()
+	}
+	res, err := BuiltinCall(t, name, strings.Join(strs, ","))
+	if err != nil {
+		return value{}, err
+	}
+	return parseValue(res), nil
+}
+
+// evaluate the expression s, with the variables of t
+func evalExpr(t *Table, s string) (value, error) {
+	e, err := parseExpr(s)
+	if err != nil {
+		return value{}, err
+	}
+	return e.eval(t, s)
+}
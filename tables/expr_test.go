@@ -0,0 +1,148 @@
+package tables
+
+/*
+ * Test the expressions of If, Calc, While and group rolls, see expr.go
+ */
+import (
+	"errors"
+	"testing"
+)
+
+func TestEvalExpr(t *testing.T) {
+	tbl := NewTable("expr")
+	tbl.AddVariable("Level", "12")
+	tbl.AddVariable("Class", "Wizard")
+	tbl.AddVariable("Weight", "-2.5")
+	tbl.AddVariable("Race", "Half-Orc")
+	tbl.AddVariable("Name", "O'Brien")
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{input: "1 + 2 * 3", expected: "7"},
+		{input: "(1 + 2) * 3", expected: "9"},
+		{input: "-3 + 1", expected: "-2"},
+		{input: "--3", expected: "3"},
+		{input: "7 / 2", expected: "3.5"},
+		{input: "7 \\ 2", expected: "3"},
+		{input: "7 mod 4", expected: "3"},
+		{input: "2 ^ 3 ^ 2", expected: "512"},
+		{input: "-2 ^ 2", expected: "-4"},
+		{input: "Level * 2", expected: "24"},
+		{input: "%Level% + 0.5", expected: "12.5"},
+		{input: "Weight * 2", expected: "-5"},
+		{input: "Level >= 12", expected: "1"},
+		{input: "Level <= 11", expected: "0"},
+		{input: "Level != 12", expected: "0"},
+		{input: "Level <> 11", expected: "1"},
+		{input: "Level == 12.0", expected: "1"},
+		{input: "9 < 10", expected: "1"},
+		{input: "Class = wizard", expected: "1"},
+		{input: "Class = \"Wizard\"", expected: "1"},
+		{input: "Class < Xylophone", expected: "1"},
+		{input: "Red Dragon = 'red dragon'", expected: "1"},
+		{input: "Class ~ Wiz*", expected: "1"},
+		{input: "Class ~ W?z?rd", expected: "1"},
+		{input: "Class ~ *ard", expected: "1"},
+		{input: "Class !~ Fight*", expected: "1"},
+		{input: "Class ~ \"wiz ard\"", expected: "0"},
+		{input: "Level > 10 and Class = Wizard", expected: "1"},
+		{input: "Level > 20 or Class = Fighter", expected: "0"},
+		{input: "not Level > 20", expected: "1"},
+		{input: "!(Level > 20) AND true", expected: "1"},
+		{input: "Level > 10 or 1 / 0", expected: "1"},
+		{input: "Level > 20 and Nowhere(1)", expected: "0"},
+		{input: "Abs(-3) + Max(1, Level, 5)", expected: "15"},
+		{input: "Round(2.456, 2)", expected: "2.46"},
+		{input: "Min(Floor(2.9), Ceil(0.2))", expected: "1"},
+		{input: "Length(Class) * 2", expected: "12"},
+		{input: "UCase(Class)", expected: "WIZARD"},
+		{input: "%Race% = \"Half-Orc\"", expected: "1"},
+		{input: "%Race% ~ Half*", expected: "1"},
+		{input: "%Race%=Half-Orc", expected: "1"},
+		{input: "%Race% <> Half-Elf and Level = Level-0", expected: "1"},
+		{input: "Level = Level-1", expected: "0"},
+		{input: "%Race% !~ %Class%", expected: "1"},
+		{input: "%Name% = \"O'Brien\" and Length(%Name%) = 7", expected: "1"},
+		{input: "%Level:03% + 1", expected: "13"},
+		{input: "{Abs~-3} * 2", expected: "6"},
+		{input: "{Calc~%Level% - 2} / [Ten]", expected: "1"},
+		{input: "Nan = Inf", expected: "0"},
+		{input: "NaN = nan", expected: "1"},
+		{input: "Infinity > 5", expected: "1"},
+	}
+	g := NewGroup(":Ten")
+	g.AddItem(1, 1, "10")
+	tbl.AddGroup(g)
+	for tcase, tt := range tests {
+		v, err := evalExpr(tbl, tt.input)
+		if err != nil || v.String() != tt.expected {
+			t.Logf("Case %d: %s wanted %s, have %s %v", tcase, tt.input, tt.expected, v, err)
+			t.Fail()
+		}
+	}
+
+	// errors are at their place in the expression as written
+	expected := `Half-Orc is not a number at 5 in "1 + %Race%"`
+	if _, err := evalExpr(tbl, "1 + %Race%"); err == nil || err.Error() != expected {
+		t.Logf("wanted %s, have %v", expected, err)
+		t.Fail()
+	}
+}
+
+func TestEvalExprErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{input: "", expected: `empty expression at 1 in ""`},
+		{input: "1 +", expected: `missing a value at the end at 4 in "1 +"`},
+		{input: "(1 + 2", expected: `missing ) for the ( at 1 at 7 in "(1 + 2"`},
+		{input: "1 + )", expected: `unexpected ) at 5 in "1 + )"`},
+		{input: "3d6", expected: `unexpected d6 at 2 in "3d6"`},
+		{input: "1 + Fred", expected: `Fred is not a number at 5 in "1 + Fred"`},
+		{input: "Infinity + 1", expected: `Infinity is not a number at 1 in "Infinity + 1"`},
+		{input: "-NaN", expected: `NaN is not a number at 2 in "-NaN"`},
+		{input: "4 / (2 - 2)", expected: `division by zero at 3 in "4 / (2 - 2)"`},
+		{input: "'open", expected: `text is not closed with ' at 1 in "'open"`},
+		{input: "1 # 2", expected: `unexpected # at 3 in "1 # 2"`},
+		{input: "Nowhere(1)", expected: `unknown function Nowhere at 1 in "Nowhere(1)"`},
+		{input: "Abs(1, 2)", expected: `wrong number of arguments to Abs at 1 in "Abs(1, 2)"`},
+		{input: "1 and", expected: `missing a value at the end at 6 in "1 and"`},
+		{input: "x ~", expected: `no pattern to match at 4 in "x ~"`},
+		{input: "%X% + 1", expected: `%X% does not exist at 1 in "%X% + 1"`},
+		{input: "1 + %X", expected: `variable name is not closed with % at 5 in "1 + %X"`},
+		{input: "{Abs~1", expected: `{ is not closed with } at 1 in "{Abs~1"`},
+	}
+	for tcase, tt := range tests {
+		_, err := evalExpr(nil, tt.input)
+		var exprErr *ExprError
+		if !errors.As(err, &exprErr) || err.Error() != tt.expected {
+			t.Logf("Case %d: %s wanted %s, have %v", tcase, tt.input, tt.expected, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestWildcardMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		expected   bool
+	}{
+		{"*", "", true},
+		{"", "", true},
+		{"", "a", false},
+		{"a*b*c", "aXbYbZc", true},
+		{"a*b*c", "aXbYbZ", false},
+		{"?", "", false},
+		{"*sword", "Long Sword", true},
+		{"l*s?ord", "long sword", true},
+	}
+	for _, tt := range tests {
+		if wildcardMatch(tt.pattern, tt.s) != tt.expected {
+			t.Logf("%q ~ %q wanted %v", tt.s, tt.pattern, tt.expected)
+			t.Fail()
+		}
+	}
+}
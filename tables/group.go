@@ -9,9 +9,9 @@ package tables
 
 import (
 	"fmt"
-	"strconv"
-
-	"github.com/nboughton/go-roll"
+	"math"
+	"rtbl/rng"
+	"sort"
 )
 
 /*
@@ -20,14 +20,37 @@ it has a unique name allowing it to be referenced
 with a link style name, e.g. [Start]
 */
 type Group struct {
-	Name     string              // unique name within a table
-	useOnce  bool                // after an entry is used it is removed
-	probType rune                // Relative or Absolute Probability
-	Prefix   string              // string placed before all random entries when returned
-	Suffix   string              // string placed after all random entries when returned
-	maxRoll  int                 // all rolls are essentially 1D{maxRoll}
-	table    roll.Table          // table of entries and their percentage chance of appearing
-	seen     map[string]struct{} // for useOnce groups, this holds previously seen values
+	Name     string           // unique name within a table
+	useOnce  bool             // after an entry is used it is removed
+	probType rune             // Relative or Absolute Probability
+	Prefix   string           // string placed before all random entries when returned
+	Suffix   string           // string placed after all random entries when returned
+	maxRoll  int              // all rolls are essentially 1D{maxRoll}
+	lowest   int              // the lowest number of any entry, modified rolls reach down to it
+	highest  int              // the highest number of any entry, MaxInt when one is open ended
+	dice     *groupDice       // the roll declared by the group, e.g. :Reaction=2d6, nil for 1D{maxRoll}
+	ways     []int            // with dice, how many rolls make each result from Min to Max
+	items    []item           // entries in the order they were added
+	segments []segment        // the numbers from Min to Max that roll each entry, in order
+	used     *remaining       // for useOnce groups, the entries not yet rolled, nil until the first roll
+	nodes    map[string]nodes // compiled entries, by their text with prefix and suffix
+}
+
+// an entry of a group, rolled on any number from start to end,
+// or from start up when open ended, e.g. 95+
+type item struct {
+	start, end int
+	open       bool
+	text       string
+}
+
+// a run of numbers that roll the same entry, an entry
+// has more than one when the ranges overlap, or are out
+// of order, as the first entry holding a number is used
+type segment struct {
+	start, end int
+	item       int // index in items
+	next       int // the next segment of the same item, round to the first
 }
 
 const ABS_GROUP = ':' // flag for Absolute Percentage Chance group
@@ -58,45 +81,161 @@ func NewGroup(name string) *Group {
 		Name:     name,
 		useOnce:  once,
 		probType: probType,
-		table: roll.Table{
-			Name: name,
-			ID:   name,
-		},
-		seen: make(map[string]struct{}), // make a set using map of empty struct
 	}
 }
 
-// the nboughton package requires an array of all 'face' values
-// since we only use numeric dice; 1d4, 1d6, 1d8 ... 1d77, 1d103
-// we must make each Die face bespoke for each table
-func makeFaces(n int) roll.Faces {
-	var f roll.Faces
+// SetDice declares the roll of a colon group, e.g. 2d6 or d66,
+// rather than 1 to the end of its last entry
+func (g *Group) SetDice(spec string) error {
+	if g.probType == REL_GROUP {
+		return fmt.Errorf("group %s: only a colon group may declare its roll, %s", g.Name, spec)
+	}
+	dice, err := parseGroupDice(spec)
+	if err != nil {
+		return fmt.Errorf("group %s: %s", g.Name, err)
+	}
+	g.dice = dice
+	return nil
+}
 
-	for j := 1; j <= n; j++ {
-		f = append(f, roll.Face{N: j, Value: strconv.Itoa(j)})
+// DiceSpec returns the roll of the group, e.g. 1d20 or 2d6
+func (g *Group) DiceSpec() string {
+	if g.dice != nil {
+		return g.dice.spec
 	}
-	return f
+	return fmt.Sprintf("1d%d", g.maxRoll)
 }
 
+// Close the group once every entry is added, the die is the
+// end of the last entry, or its start when open ended, unless
+// the group declares its roll, every roll of it is mapped to an entry
 func (g *Group) Close() {
-	last := len(g.table.Items)
+	last := len(g.items)
 	if last == 0 {
 		return
 	}
-	match := g.table.Items[last-1].Match
-	g.maxRoll = 0
-	for j := 0; j < len(match); j++ {
-		if match[j] > g.maxRoll {
-			g.maxRoll = match[j]
+	g.maxRoll = g.items[last-1].end
+	g.ways = nil
+	if g.dice != nil {
+		g.maxRoll = g.dice.max()
+		g.ways = g.dice.ways()
+	}
+	g.segments = nil
+
+	g.lowest, g.highest = g.items[0].start, g.items[0].end
+	for _, it := range g.items {
+		if it.start < g.lowest {
+			g.lowest = it.start
+		}
+		if it.open {
+			g.highest = math.MaxInt
+		} else if it.end > g.highest {
+			g.highest = it.end
+		}
+	}
+
+	inOrder := true
+	for j := 1; j < last; j++ {
+		if g.items[j-1].open || g.items[j].start <= g.items[j-1].end {
+			inOrder = false
+			break
+		}
+	}
+	if inOrder {
+		for j, it := range g.items {
+			start, end := it.start, it.end
+			if start < g.Min() {
+				start = g.Min()
+			}
+			if end > g.maxRoll || it.open {
+				end = g.maxRoll
+			}
+			if start <= end {
+				k := len(g.segments)
+				g.segments = append(g.segments, segment{start: start, end: end, item: j, next: k})
+			}
+		}
+	} else {
+		for n := g.Min(); n <= g.maxRoll; n++ {
+			j := g.firstItem(n)
+			if j == -1 {
+				continue
+			}
+			if k := len(g.segments) - 1; k >= 0 && g.segments[k].item == j && g.segments[k].end == n-1 {
+				g.segments[k].end = n
+				continue
+			}
+			g.segments = append(g.segments, segment{start: n, end: n, item: j})
+		}
+		// link the segments of each item in a ring, for use once removal
+		firstOf := make(map[int]int)
+		lastOf := make(map[int]int)
+		for k := range g.segments {
+			j := g.segments[k].item
+			if prev, ok := lastOf[j]; ok {
+				g.segments[prev].next = k
+			} else {
+				firstOf[j] = k
+			}
+			lastOf[j] = k
+		}
+		for j, k := range lastOf {
+			g.segments[k].next = firstOf[j]
 		}
 	}
-	g.table.Dice = roll.Dice{N: 1, Die: roll.NewDie(makeFaces(g.maxRoll))}
+	g.compile()
 }
 
-func (g *Group) Len() int { return len(g.table.Items) }
-func (g *Group) Min() int { return 1 }
+func (g *Group) Len() int { return len(g.items) }
 func (g *Group) Max() int { return g.maxRoll }
 
+func (g *Group) Min() int {
+	if g.dice != nil {
+		return g.dice.min()
+	}
+	return 1
+}
+
+// true when n may be rolled on the group
+func (g *Group) possible(n int) bool {
+	if n < g.Min() || n > g.Max() {
+		return false
+	}
+	return g.ways == nil || g.ways[n-g.Min()] > 0
+}
+
+// how many rolls of the group make a number in the segment
+func (g *Group) weight(seg segment) int {
+	if g.ways == nil {
+		return seg.end - seg.start + 1
+	}
+	w := 0
+	for n := seg.start; n <= seg.end; n++ {
+		w += g.ways[n-g.Min()]
+	}
+	return w
+}
+
+// keep n within the rolls of the group, used by modified rolls,
+// a modified roll may reach an entry the die can not, e.g. -3--1
+// or 95+, so the lowest and highest entries widen the rolls
+func (g *Group) clamp(n int) int {
+	low, high := g.Min(), g.Max()
+	if g.lowest < low {
+		low = g.lowest
+	}
+	if g.highest > high {
+		high = g.highest
+	}
+	if n < low {
+		return low
+	}
+	if n > high {
+		return high
+	}
+	return n
+}
+
 // add a single item to this group with its matching percentage
 func (g *Group) AddItem(start, end int, l string) {
 
@@ -104,95 +243,134 @@ func (g *Group) AddItem(start, end int, l string) {
 		// only start has meaning for a relative group
 		// these groups have a single int that is relative
 		// to all the entries
-		last := len(g.table.Items)
+		last := len(g.items)
 		if last == 0 {
 			end = start
 			start = 1
 		} else {
-			match := g.table.Items[last-1].Match
-			largest := 0
-			for j := 0; j < len(match); j++ {
-				if match[j] > largest {
-					largest = match[j]
-				}
-			}
+			largest := g.items[last-1].end
 			end = largest + start
 			start = largest + 1
 		}
 	}
-	g.table.Items = append(g.table.Items,
-		roll.TableItem{
-			Match: roll.MatchRange(start, end),
-			Text:  l,
-		})
+	if end < start { // a single number
+		end = start
+	}
+	g.items = append(g.items, item{start: start, end: end, text: l})
+}
 
+// add an open ended item to a colon group, rolled on start
+// and every number above it, e.g. 95+
+func (g *Group) AddOpenItem(start int, l string) {
+	g.items = append(g.items, item{start: start, end: start, open: true, text: l})
 }
 
 // append string argument to the last Item
 // used during parsing
 // used to support underscore(_) continuation
 func (g *Group) AppendLastItem(l string) error {
-	if len(g.table.Items) == 0 {
+	if len(g.items) == 0 {
 		return fmt.Errorf("Can not append, no items in Group %s", g.Name)
 	}
-	last := len(g.table.Items) - 1
+	last := len(g.items) - 1
 
-	g.table.Items[last].Text = g.table.Items[last].Text + l
+	g.items[last].text = g.items[last].text + l
 
 	return nil
 }
 
-// defined as an empty struct so it takes no space in the map
-// which is how we implement sets in golang
-var dummy struct{}
+// the text of the entry whose range contains n
+func (g *Group) entry(n int) string {
+	if j := g.itemAt(n); j != -1 {
+		return g.items[j].text
+	}
+	return ""
+}
+
+// the index of the entry rolled on n, -1 when no entry holds n
+func (g *Group) itemAt(n int) int {
+	if n < g.Min() || n > g.maxRoll || g.segments == nil {
+		return g.firstItem(n)
+	}
+	k := sort.Search(len(g.segments), func(k int) bool { return g.segments[k].end >= n })
+	if k < len(g.segments) && g.segments[k].start <= n {
+		return g.segments[k].item
+	}
+	return -1
+}
+
+// the index of the first entry whose range holds n, -1 for none
+func (g *Group) firstItem(n int) int {
+	for j := range g.items {
+		if g.items[j].start <= n && (n <= g.items[j].end || g.items[j].open) {
+			return j
+		}
+	}
+	return -1
+}
 
 // randomly select an entry from the group and apply prefix and suffix
 // to returned value
-// if this is a useOnce group,loop until a unique value can be
-// returned
-// this is implementaiton of UseOnce groups, :!Gear
+// a useOnce group, :!Gear, rolls only on the entries not yet
+// returned, nothing is returned once every entry is used
+// every roll is drawn from the rng package
 func (g *Group) Roll() string {
-	var s string
-	// let not loop infinetely
-	if g.useOnce {
-		if len(g.seen) == len(g.table.Items) {
-			return ""
+	_, s := g.roll(rng.Get())
+	return s
+}
+
+// roll returns the number rolled along with the selected entry,
+// the number is 0 when nothing could be selected
+func (g *Group) roll(r rng.Source) (int, string) {
+	if g.maxRoll < g.Min() {
+		return 0, ""
+	}
+	if !g.useOnce {
+		var n int
+		if g.dice != nil {
+			n = g.dice.roll(r)
+		} else {
+			n = r.Intn(g.maxRoll) + 1
 		}
+		return n, g.Select(n)
 	}
-	//repeatedly select a value until done
-	for {
-		s = g.table.Roll()
-		_, alreadyUsed := g.seen[s]
-		if !g.useOnce || !alreadyUsed {
+
+	// a use once group rolls only on the entries not used,
+	// weighted by how many rolls make each
+	if g.used == nil {
+		weights := make([]int, len(g.segments))
+		for k, seg := range g.segments {
+			weights[k] = g.weight(seg)
+		}
+		g.used = newRemaining(weights)
+	}
+	if g.used.total == 0 {
+		return 0, ""
+	}
+	k, offset := g.used.find(r.Intn(g.used.total))
+	seg := g.segments[k]
+	for j := k; ; {
+		g.used.remove(j, g.weight(g.segments[j]))
+		if j = g.segments[j].next; j == k {
 			break
 		}
 	}
-	// if this is a useOnce group save the returned value
-	// so we can check to see if we used it next time
-	if g.useOnce {
-		g.seen[s] = dummy
+	n := seg.start + offset
+	if g.ways != nil {
+		for n = seg.start; offset >= g.ways[n-g.Min()]; n++ {
+			offset -= g.ways[n-g.Min()]
+		}
 	}
-	return g.Prefix + s + g.Suffix
+	return n, g.Prefix + g.items[seg.item].text + g.Suffix
 }
 
 // select the Nth item from the table
 func (g *Group) Select(n int) string {
-	var s string
-
-	//repeatedly select a value until done
-	for j := range g.table.Items {
-		if g.table.Items[j].Match.Contains(n) {
-			s = g.table.Items[j].Text
-			break
-		}
-	}
-	return g.Prefix + s + g.Suffix
+	return g.Prefix + g.entry(n) + g.Suffix
 }
 
 // Reset the state of the Group
 // - delete the already used entries, so they can be re-used
 func (g *Group) Reset() {
-	for k := range g.seen {
-		delete(g.seen, k)
-	}
+	g.used = nil
 }
@@ -0,0 +1,292 @@
+package tables
+
+/*
+ * Lint checks a parsed table for problems that would otherwise
+ * only show up as --ERROR text, or silently wrong results, when
+ * the table is rolled. Nothing is rolled while linting.
+ */
+
+import (
+	"fmt"
+	"regexp"
+	"rtbl/stringsext"
+	"strconv"
+	"strings"
+)
+
+type LintIssue struct {
+	Table   string // path of the table file
+	Group   string // empty when the issue is not in a group
+	Message string
+}
+
+func (i LintIssue) String() string {
+	if i.Group == "" {
+		return fmt.Sprintf("%s: %s", i.Table, i.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", i.Table, i.Group, i.Message)
+}
+
+var (
+	// |Name op value| inline variable assignment
+	lexAssign = regexp.MustCompile(`\|(\w+)[+\-*/\\><&=]`)
+	// {ListAdd~Name,item} adds to, or makes, a list variable
+	lexListAdd = regexp.MustCompile(`(?i)\{ListAdd~\s*(\w+)\s*,`)
+)
+
+type linter struct {
+	t          *Table
+	group      string
+	issues     []LintIssue
+	assigned   map[string]bool // variables assigned in entry text
+	referenced map[string]bool // groups referenced in this table
+}
+
+func (l *linter) report(format string, args ...interface{}) {
+	path := l.t.Path
+	if path == "" {
+		path = l.t.Name
+	}
+	l.issues = append(l.issues, LintIssue{
+		Table:   path,
+		Group:   l.group,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// Lint returns every problem found in the table, an empty
+// list means the table is clean
+func Lint(t *Table) []LintIssue {
+	l := &linter{
+		t:          t,
+		assigned:   make(map[string]bool),
+		referenced: make(map[string]bool),
+	}
+
+	// duplicate groups, the last one parsed replaced the others
+	seen := make(map[string]bool)
+	var names []string
+	for _, name := range t.groupNames {
+		if seen[name] {
+			l.report("duplicate group %s, only the last one is used", name)
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+
+	// variables may be assigned anywhere in the table and used
+	// anywhere else, so collect them all before checking uses
+	for _, name := range names {
+		g := t.Groups[name]
+		for _, item := range g.items {
+			for _, m := range lexAssign.FindAllStringSubmatch(item.text, -1) {
+				l.assigned[m[1]] = true
+			}
+			for _, m := range lexListAdd.FindAllStringSubmatch(item.text, -1) {
+				l.assigned[m[1]] = true
+			}
+		}
+	}
+
+	for _, name := range names {
+		g := t.Groups[name]
+		l.group = name
+		if g.Len() == 0 {
+			l.report("group has no entries")
+			continue
+		}
+		if g.probType == ABS_GROUP {
+			l.checkRanges(g)
+		}
+		l.checkText(g.Prefix)
+		l.checkText(g.Suffix)
+		for _, item := range g.items {
+			l.checkBalance(item.text)
+			l.checkText(item.text)
+		}
+	}
+
+	l.group = ""
+	for _, name := range names {
+		if name != "Start" && !l.referenced[name] {
+			l.group = name
+			l.report("group is never referenced")
+		}
+	}
+	return l.issues
+}
+
+// format a list of numbers as ranges, e.g. 1-3, 7, 9-10
+func formatRanges(nums []int) string {
+	var parts []string
+	for j := 0; j < len(nums); {
+		k := j
+		for k+1 < len(nums) && nums[k+1] == nums[k]+1 {
+			k++
+		}
+		if k == j {
+			parts = append(parts, strconv.Itoa(nums[j]))
+		} else if nums[j] < 0 { // -3--1 is hard to read
+			parts = append(parts, fmt.Sprintf("%d to %d", nums[j], nums[k]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", nums[j], nums[k]))
+		}
+		j = k + 1
+	}
+	return strings.Join(parts, ", ")
+}
+
+// check the ranges of a colon group for gaps, overlaps
+// and entries that can never be rolled, against every
+// result of the roll of the group
+func (l *linter) checkRanges(g *Group) {
+	count := make(map[int]int)
+	for _, item := range g.items {
+		// a range may span numbers that can not be rolled, e.g.
+		// 31-66 on a d66, only an entry that can never be
+		// rolled, or reaches beyond the roll, is reported
+		// numbers below 1 and the roll, and the numbers above an
+		// open ended entry, are reached by modified rolls, e.g.
+		// [Group-3], a roll of 2d6-5 reaches -3 itself
+		end := item.end
+		if item.open && g.Max() > end {
+			end = g.Max()
+		}
+		var beyond, never []int
+		for n := item.start; n <= end; n++ {
+			if n < 1 && n < g.Min() {
+				continue
+			}
+			if n < g.Min() || n > g.Max() {
+				beyond = append(beyond, n)
+			} else if g.possible(n) {
+				count[n]++
+			} else {
+				never = append(never, n)
+			}
+		}
+		if len(beyond) == 0 && len(never) > 0 && len(never) == end-item.start+1 {
+			beyond = never
+		}
+		if len(beyond) > 0 {
+			l.report("entry %s is beyond the die size %s and can never be rolled: %s",
+				formatRanges(beyond), g.DiceSpec(), item.text)
+		}
+	}
+	var gaps, overlaps []int
+	for n := g.Min(); n <= g.Max(); n++ {
+		if !g.possible(n) {
+			continue
+		}
+		if count[n] == 0 {
+			gaps = append(gaps, n)
+		} else if count[n] > 1 {
+			overlaps = append(overlaps, n)
+		}
+	}
+	if len(gaps) > 0 {
+		l.report("no entry for rolls %s", formatRanges(gaps))
+	}
+	if len(overlaps) > 0 {
+		l.report("more than one entry for rolls %s", formatRanges(overlaps))
+	}
+}
+
+// check that brackets, braces and percents are balanced
+func (l *linter) checkBalance(s string) {
+	for _, pair := range []string{"[]", "{}"} {
+		depth := 0
+		for j := 0; j < len(s); j++ {
+			if s[j] == pair[0] {
+				depth++
+			} else if s[j] == pair[1] {
+				depth--
+				if depth < 0 {
+					break
+				}
+			}
+		}
+		if depth < 0 {
+			l.report("unbalanced %c, no matching %c: %s", pair[1], pair[0], s)
+		} else if depth > 0 {
+			l.report("unbalanced %c, no matching %c: %s", pair[0], pair[1], s)
+		}
+	}
+	if strings.Count(s, "%")%2 != 0 {
+		l.report("unbalanced %%, variable name is not closed: %s", s)
+	}
+}
+
+// walk entry text checking every reference, builtin call and variable
+func (l *linter) checkText(s string) {
+	for j := 0; j < len(s); j++ {
+		switch s[j] {
+		case '[':
+			sub, last := findEndDelim(s[j+1:], "[", "]")
+			j += last + 1
+			l.checkText(sub)
+			l.checkRef(sub)
+		case '{':
+			sub, last := findEndDelim(s[j+1:], "{", "}")
+			j += last + 1
+			name := stringsext.First(sub, "~")
+			args := stringsext.Rest(sub, "~")
+			if !isBuiltin(name) {
+				l.report("unknown builtin %s", name)
+			} else if strings.EqualFold(name, "Reset") {
+				l.referenced[args] = true
+			}
+			l.checkText(args)
+		case '%':
+			idx := strings.Index(s[j+1:], "%")
+			if idx == -1 {
+				break // reported by checkBalance
+			}
+			name := s[j+1 : j+1+idx]
+			if k := strings.IndexByte(name, ':'); k != -1 {
+				if _, err := parseVarFormat(name[k+1:]); err != nil {
+					l.report("variable %%%s%%: %s", name, err)
+				}
+				name = name[:k]
+			}
+			_, declared := l.t.GetVariable(name)
+			if !declared && !l.assigned[name] {
+				l.report("variable %%%s%% is never declared", name)
+			}
+			j += idx + 1
+		}
+	}
+}
+
+// check a [reference] to a group in this table, or another table
+func (l *linter) checkRef(ref string) {
+	name, _, _ := l.t.splitRef(ref)
+	name = strings.TrimSpace(stringsext.First(name, "("))
+	if strings.ContainsAny(name, "%{") {
+		return // the name is only known when rolled
+	}
+	if name == "" {
+		l.report("empty group reference [%s]", ref)
+		return
+	}
+	if !strings.Contains(name, ".") {
+		if _, ok := l.t.Groups[name]; ok {
+			l.referenced[name] = true
+			return
+		}
+	}
+	loadedTable, groupName, err := l.t.tableRegistry().resolveRef(name, l.t.Name)
+	if err != nil {
+		l.report("%s", err)
+		return
+	}
+	other, err := loadedTable.Parse()
+	if err != nil {
+		l.report("table %s in [%s] does not parse: %s", loadedTable.name, ref, err)
+		return
+	}
+	if _, err := other.GetGroup(groupName); err != nil {
+		l.report("%s", err)
+	}
+}
@@ -0,0 +1,101 @@
+package tables
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	tbl := NewTable("lint")
+	tbl.AddVariable("Level", "1")
+
+	g := NewGroup(":Start")
+	g.AddItem(1, 2, "[Color] %Level% %Missing% |Temp=3|%Temp% {ListAdd~Loot,gem}%Loot%")
+	g.AddItem(4, 6, "{NoSuchFunc~x} [Nowhere]")
+	g.AddItem(6, 6, "{UCase~[Color]")
+	tbl.AddGroup(g)
+
+	g = NewGroup(":Color")
+	g.AddItem(3, 4, "red")
+	g.AddItem(1, 2, "blue")
+	tbl.AddGroup(g)
+
+	g = NewGroup(";Unused")
+	g.AddItem(1, 0, "never rolled")
+	tbl.AddGroup(g)
+
+	g = NewGroup(";Unused")
+	g.AddItem(1, 0, "twice")
+	tbl.AddGroup(g)
+
+	expected := []string{
+		"lint: duplicate group Unused, only the last one is used",
+		"lint: Start: no entry for rolls 3",
+		"lint: Start: more than one entry for rolls 6",
+		"lint: Start: variable %Missing% is never declared",
+		"lint: Start: unknown builtin NoSuchFunc",
+		"lint: Start: no group or table named Nowhere",
+		"lint: Start: unbalanced {, no matching }: {UCase~[Color]",
+		"lint: Color: entry 3-4 is beyond the die size 1d2 and can never be rolled: red",
+		"lint: Unused: group is never referenced",
+	}
+	var have []string
+	for _, issue := range Lint(tbl) {
+		have = append(have, issue.String())
+	}
+	if strings.Join(have, "\n") != strings.Join(expected, "\n") {
+		t.Logf("wanted:\n%s\nhave:\n%s", strings.Join(expected, "\n"), strings.Join(have, "\n"))
+		t.Fail()
+	}
+}
+
+func TestLintGroupDice(t *testing.T) {
+	tbl := NewTable("lint")
+	g := NewGroup(":Start")
+	g.AddItem(1, 1, "[Reaction] [Events]")
+	tbl.AddGroup(g)
+
+	g = NewGroup(":Reaction")
+	g.AddItem(1, 6, "hostile")
+	g.AddItem(8, 12, "friendly")
+	g.SetDice("2d6")
+	tbl.AddGroup(g)
+
+	g = NewGroup(":Events")
+	g.AddItem(11, 16, "quiet")
+	g.AddItem(17, 20, "lost")
+	g.AddItem(31, 66, "trouble") // 37-40 is never rolled, and not reported
+	g.SetDice("d66")
+	tbl.AddGroup(g)
+
+	// a modified roll, 2d6-5, rolls -3 to 7
+	g = NewGroup(":Start")
+	g.AddItem(-3, -1, "cold")
+	g.AddItem(0, 3, "mild")
+	g.AddItem(4, 7, "hot")
+	g.SetDice("2d6-5")
+	sub := NewTable("weather")
+	sub.AddGroup(g)
+	g = NewGroup(":Wind")
+	g.AddItem(-3, -3, "none")
+	g.AddItem(2, 7, "strong")
+	g.SetDice("2d6-5")
+	sub.AddGroup(g)
+
+	expected := []string{
+		"lint: Reaction: entry 1 is beyond the die size 2d6 and can never be rolled: hostile",
+		"lint: Reaction: no entry for rolls 7",
+		"lint: Events: entry 17-20 is beyond the die size d66 and can never be rolled: lost",
+		"lint: Events: no entry for rolls 21-26",
+		"weather: Wind: no entry for rolls -2 to 1",
+		"weather: Wind: group is never referenced",
+	}
+	var have []string
+	for _, issue := range append(Lint(tbl), Lint(sub)...) {
+		have = append(have, issue.String())
+	}
+	if strings.Join(have, "\n") != strings.Join(expected, "\n") {
+		t.Logf("wanted:\n%s\nhave:\n%s", strings.Join(expected, "\n"), strings.Join(have, "\n"))
+		t.Fail()
+	}
+}
@@ -3,6 +3,7 @@ package tables
 import (
 	"bufio"
 	"fmt"
+	"io/fs"
 	"os"
 	"path"
 	"sort"
@@ -64,6 +65,21 @@ func FindTables(root string) (paths []string, err error) {
 	return result, err
 }
 
+// find the path of every table file in fsys
+func findTablesFS(fsys fs.FS) ([]string, error) {
+	var result []string
+	err := fs.WalkDir(fsys, ".", func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(filePath, ".tab") {
+			result = append(result, filePath)
+		}
+		return nil
+	})
+	return result, err
+}
+
 type TablesByCategory map[string][]string
 
 func NewTablesByCategory(paths []string) TablesByCategory {
@@ -85,32 +101,6 @@ func NewTablesByCategory(paths []string) TablesByCategory {
 	return tables
 }
 
-type LoadedTable struct {
-	path  string
-	table *Table
-}
-
-type TablePathsByName map[string]*LoadedTable // map table name to paths
-
-func NewTableList(paths []string) TablePathsByName {
-
-	tables := make(TablePathsByName)
-
-	// make a map of all tables in all catagories
-	// tables are paths that end in .tab
-	// categories are the containing directory
-	// e.g. Names/Greek.tab
-	for _, filepath := range paths {
-		if strings.HasSuffix(filepath, ".tab") {
-			name := path.Base(filepath)
-			name = strings.TrimSuffix(name, ".tab")
-			name = strings.ToLower(name) // hold all names as lower case
-			tables[name] = &LoadedTable{filepath, nil}
-		}
-	}
-	return tables
-}
-
 func PrintPaths(tables TablesByCategory, showAll bool) {
 
 	// sort the keys(categories) so they
@@ -129,19 +119,3 @@ func PrintPaths(tables TablesByCategory, showAll bool) {
 		}
 	}
 }
-
-// Most import Variable -- holds all table references where
-// Parse/Lookup can find tables ....
-var TableRegistry TablePathsByName
-
-func LoadAllTables(rootpath string) error {
-	paths, err := FindTables(rootpath)
-	if err != nil {
-		return err
-	}
-	if len(paths) == 0 {
-		return fmt.Errorf("No tables found")
-	}
-	TableRegistry = NewTableList(paths)
-	return nil
-}
@@ -2,16 +2,31 @@ package tables
 
 import (
 	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
 )
 
+var (
+	// Name op, the start of an inline assignment |Name op value|
+	lexInlineAssign = regexp.MustCompile(`^(\w+)([+\-*/\\><&=])`)
+	// a variable, %Name% or %Name:format%
+	lexVariableRef = regexp.MustCompile(`^%\w+(:[0-9,.]*)?%`)
+)
+
+// Roll generates a result from the group, a table that is not
+// a Session's copy is rolled in a new Session
 func (t *Table) Roll(gn string) string {
 	//return t.OldRoll(gn)
 	return t.TryRoll(gn)
 }
 
 func (t *Table) TryRoll(gn string) string {
+	t = t.inSession()
+	defer t.begin()()
+	if t.cancelled() {
+		return ""
+	}
 
 	var gen string
 
@@ -19,103 +34,228 @@ func (t *Table) TryRoll(gn string) string {
 	// is passed in the reference as follows;
 	// [Group=%Number%]
 	// This is same as lookin up Group table at row 'Number'
-	if gn[0] == '[' {
+	// the roll may also be modified, [Group+5] or [Group-%Level%]
+	if len(gn) > 0 && gn[0] == '[' {
 		gn = gn[1:]
 	}
-	if gn[len(gn)-1] == ']' {
+	if len(gn) > 0 && gn[len(gn)-1] == ']' {
 		gn = gn[:len(gn)-1]
 	}
-	words := strings.Split(gn, "=")
-	pick := -1
-	var err error
-	if len(words) == 2 {
-		gn = words[0]
-		pick, err = strconv.Atoi(words[1])
-		if err != nil {
-			return fmt.Sprintf("%s does not select an integer", gn)
-		}
-	}
+	// arguments to the table parameters, [Group(a1,a2)]
+	gn, args, hasArgs := splitArgs(gn)
+	gn, op, modexpr := t.splitRef(gn)
 	g := t.Groups[gn]
 	if g == nil {
-		return gn
+		return t.rollForeign(gn, op, modexpr, args, hasArgs)
+	}
+	if hasArgs {
+		restore := t.saveParams()
+		defer restore()
+		if err := t.BindArgs(args); err != nil {
+			return t.rollError(gn+op+modexpr, err)
+		}
+	}
+	mod := 0
+	if op != "" {
+		var err error
+		mod, err = t.evalModifier(modexpr)
+		if err != nil {
+			return t.rollError(gn+op+modexpr, err)
+		}
 	}
 
-	if pick == -1 {
-		gen = g.Roll()
-	} else {
+	node := t.traceBegin(&TraceNode{Kind: TRACE_ROLL, Table: t.Name, Group: gn, Die: g.Max()})
+	if node != nil && g.dice != nil {
+		node.Dice = g.dice.spec
+	}
+	var pick int
+	switch op {
+	case "=":
+		pick = g.clamp(mod)
 		gen = g.Select(pick)
+	case "+", "-":
+		if op == "-" {
+			mod = -mod
+		}
+		pick, _ = g.roll(t.random())
+		pick = g.clamp(pick + mod)
+		gen = g.Select(pick)
+	default:
+		pick, gen = g.roll(t.random())
+	}
+	if node != nil {
+		node.Roll = pick
+		node.Modifier = op + modexpr
+		node.Entry = gen
 	}
 
-	gen = t.Evaluate(gen)
+	gen = t.render(g.compiled(gen))
 
+	t.traceEnd(node, gen, nil)
 	return gen
 }
 
+func (t *Table) rollError(ref string, err error) string {
+	t.evalError("Rolling Group", "["+ref+"]", err)
+	return fmt.Sprintf("\n--ERROR Rolling Group-- [%s]: %s\n", ref, err)
+}
+
+// roll on a group in another table, [Table.Group], or on
+// the Start group of another table, [Table]
+// the table is found in the TableRegistry, relative to the category
+// of this table first, and parsed when first used
+func (t *Table) rollForeign(name, op, mod string, args []string, hasArgs bool) string {
+	ref := name + op + mod
+	loadedTable, groupName, err := t.tableRegistry().resolveRef(name, t.Name)
+	if err != nil {
+		return t.rollError(ref, err)
+	}
+	other, err := loadedTable.Parse()
+	if err != nil {
+		return t.rollError(ref, fmt.Errorf("table %s does not parse: %s", loadedTable.name, err))
+	}
+	if _, err := other.GetGroup(groupName); err != nil {
+		return t.rollError(ref, err)
+	}
+	// the other table is rolled, and traced, in the same session
+	other = t.session.Table(other)
+	if hasArgs {
+		restore := other.saveParams()
+		defer restore()
+		if err := other.BindArgs(args); err != nil {
+			return t.rollError(ref, err)
+		}
+	}
+	return other.TryRoll(groupName + op + mod)
+}
+
+// split a group reference into the group name and an optional
+// roll modifier, e.g. Target+50 is Target, +, 50
+// group names may themselves contain + - or =, so the first split
+// that names a group of this table is used
+func (t *Table) splitRef(ref string) (name, op, mod string) {
+	if _, ok := t.Groups[ref]; ok {
+		return ref, "", ""
+	}
+	first := -1
+	for j := 0; j < len(ref); j++ {
+		if !strings.ContainsRune("=+-", rune(ref[j])) {
+			continue
+		}
+		if first == -1 {
+			first = j
+		}
+		if _, ok := t.Groups[ref[:j]]; ok {
+			return ref[:j], ref[j : j+1], ref[j+1:]
+		}
+	}
+	// not a group here, maybe another table's group
+	if first > 0 {
+		return ref[:first], ref[first : first+1], ref[first+1:]
+	}
+	return ref, "", ""
+}
+
+// the value of a roll modifier, either an integer or an expression
+// e.g. 50, 12+2, (Level*2)
+// variables and builtins have already been evaluated by Evaluate
+func (t *Table) evalModifier(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	n, err := strconv.Atoi(s)
+	if err == nil {
+		return n, nil
+	}
+	v, err := evalExpr(t, s)
+	if err != nil {
+		return 0, err
+	}
+	f, ok := v.number()
+	if !ok {
+		return 0, fmt.Errorf("%s is not a number", s)
+	}
+	return int(f), nil
+}
+
 // Starting from the beginning of s, find the end bracket, allow for nesting
+// the text before the end bracket and the index of the bracket are returned
+// when there is no end bracket all of s is returned
 func findEndDelim(s string, begin string, end string) (subStr string, lastIndex int) {
 	n := 0
-	lst := 0
-	ret := ""
-	for lst = 0; (n > 0 && s[lst] == ']') || lst < len(s); lst++ {
+	for lst := 0; lst < len(s); lst++ {
 		c := s[lst : lst+1]
 		if c == begin { // found a nested reference
 			n += 1
-			ret += c
 		} else if c == end {
+			if n == 0 {
+				return s[:lst], lst
+			}
 			n -= 1
-			if n > 0 { // keep sub references
-				ret += c
-			} else {
-				return ret, lst
+		}
+	}
+	return s, len(s)
+}
+
+// find the pipe that ends an inline assignment
+// -1 is returned when there is no end pipe
+func findAssignEnd(s string) int {
+	return findUnnested(s, '|')
+}
+
+// find c outside of builtin calls, group references and
+// variables, the : of %Gold:,% is not found
+// -1 is returned when there is none
+func findUnnested(s string, c byte) int {
+	depth := 0
+	for j := 0; j < len(s); j++ {
+		if s[j] == '%' {
+			if m := lexVariableRef.FindString(s[j:]); m != "" {
+				j += len(m) - 1
+				continue
+			}
+		}
+		switch s[j] {
+		case '{', '[':
+			depth++
+		case '}', ']':
+			depth--
+		case c:
+			if depth <= 0 {
+				return j
 			}
-		} else {
-			ret += c
 		}
 	}
-	return ret, lst
+	return -1
+}
+
+// split s at each c outside of builtin calls and group references
+func splitUnnested(s string, c byte) []string {
+	var parts []string
+	for {
+		idx := findUnnested(s, c)
+		if idx == -1 {
+			return append(parts, s)
+		}
+		parts = append(parts, s[:idx])
+		s = s[idx+1:]
+	}
 }
 
 /*
 2,hexagonal|TempNumber={Ceil~{Calc~(%ValueFactor%*0.09)}}||ValueFactor=%TempNumber%|
 1,crescent-shaped|TempNumber={Ceil~{Calc~(%ValueFactor%*0.05)}}||ValueFactor=%TempNumber%|
 */
+// Evaluate generates text from s written like a table entry,
+// see compile. Entries of groups are compiled when parsed
 func (t *Table) Evaluate(s string) string {
-
-	gen := ""
-
-	for j := 0; j < len(s); j++ {
-		switch s[j] {
-		case '[':
-			sub, last := findEndDelim(s[j+1:], "[", "]")
-			j += last
-			sub = t.Evaluate(sub)
-			gen += t.Roll(sub)
-		case '{':
-			sub, last := findEndDelim(s[j+1:], "{", "}")
-			j += last
-			sub = t.Evaluate(sub)
-			words := strings.Split(sub, "~")
-			res, err := BuiltinCall(t, words[0], words[1])
-			if err != nil {
-				return "\n--ERROR Calling Builtin-- " + fmt.Sprintf("%s(%s): %s\n", words[0], words[1], err)
-			}
-			gen += res
-		case '%':
-			j += 1
-			idx := strings.Index(s[j:], "%")
-			varName := s[j : j+idx]
-			v, ok := t.GetVariable(varName)
-			if ok {
-				gen += t.Evaluate(v)
-			} else {
-				return "\n--ERROR Accessing Variable-- %" + s[j:idx] + "% does not exist"
-			}
-			j += idx
-		default:
-			gen += s[j : j+1]
-		}
+	t = t.inSession()
+	defer t.begin()()
+	if t.cancelled() {
+		return ""
 	}
-	return gen
+	if !strings.ContainsAny(s, SPECIAL_CHARS) {
+		return s
+	}
+	return t.render(compile(s))
 }
 
 func evalBuiltin(s string) string {
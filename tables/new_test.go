@@ -0,0 +1,248 @@
+package tables
+
+/*
+ * Test the generation of results from a Table, see new.go
+ */
+import (
+	"testing"
+)
+
+// build a small table in memory, all groups have a single entry
+// so results are always the same
+func makeTestTable() *Table {
+	tbl := NewTable("test")
+	tbl.AddVariable("Level", "3")
+
+	g := NewGroup(":Start")
+	g.AddItem(1, 1, "A {UCase~[Color]} [Beast] of level %Level%")
+	tbl.AddGroup(g)
+
+	g = NewGroup(":Color")
+	g.AddItem(1, 1, "red")
+	tbl.AddGroup(g)
+
+	g = NewGroup(";Beast")
+	g.AddItem(1, 0, "dragon")
+	tbl.AddGroup(g)
+	return tbl
+}
+
+func TestFindEndDelim(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		last     int
+	}{
+		{input: "Color]", expected: "Color", last: 5},
+		{input: "A[B]C] rest", expected: "A[B]C", last: 5},
+		{input: "no end", expected: "no end", last: 6},
+	}
+
+	for tcase, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			sub, last := findEndDelim(tt.input, "[", "]")
+			if sub != tt.expected || last != tt.last {
+				t.Logf("Case %d: wanted %s,%d have %s,%d", tcase, tt.expected, tt.last, sub, last)
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	tbl := makeTestTable()
+	res := tbl.Roll("Start")
+	if res != "A RED dragon of level 3" {
+		t.Logf("wanted 'A RED dragon of level 3' have '%s'", res)
+		t.Fail()
+	}
+}
+
+func TestRollTrace(t *testing.T) {
+	tbl := makeTestTable()
+	res, trace := tbl.RollTrace("Start")
+	if trace.Result != res {
+		t.Logf("trace result %s does not match %s", trace.Result, res)
+		t.Fail()
+	}
+	if len(trace.Children) != 1 {
+		t.Fatalf("expected 1 roll on Start, have %d", len(trace.Children))
+	}
+	start := trace.Children[0]
+	if start.Kind != TRACE_ROLL || start.Group != "Start" || start.Roll != 1 || start.Die != 1 {
+		t.Logf("Start roll is wrong: %+v", start)
+		t.Fail()
+	}
+	// UCase builtin, Beast roll and Level variable
+	if len(start.Children) != 3 {
+		t.Fatalf("expected 3 children of Start, have %d\n%s", len(start.Children), trace)
+	}
+	ucase := start.Children[0]
+	if ucase.Kind != TRACE_BUILTIN || ucase.Name != "UCase" || ucase.Args != "red" || ucase.Result != "RED" {
+		t.Logf("UCase call is wrong: %+v", ucase)
+		t.Fail()
+	}
+	if len(ucase.Children) != 1 || ucase.Children[0].Group != "Color" {
+		t.Logf("Color roll is not nested in UCase\n%s", trace)
+		t.Fail()
+	}
+	level := start.Children[2]
+	if level.Kind != TRACE_VARIABLE || level.Name != "Level" || level.Result != "3" {
+		t.Logf("Level variable read is wrong: %+v", level)
+		t.Fail()
+	}
+	// tracing stops after the roll
+	tbl.Roll("Start")
+	if len(trace.Children) != 1 {
+		t.Log("trace recorded after RollTrace returned")
+		t.Fail()
+	}
+
+	// variables read by a condition are traced under the builtin
+	tbl.AddVariable("Gold", "12")
+	g := NewGroup(":Rich")
+	g.AddItem(1, 1, "{If~%Level% > 3 or Gold > 10?rich/poor}")
+	tbl.AddGroup(g)
+	_, trace = tbl.RollTrace("Rich")
+	cond := trace.Children[0].Children[0]
+	if cond.Kind != TRACE_BUILTIN || cond.Name != "If" || len(cond.Children) != 2 {
+		t.Fatalf("If is not traced with its variables\n%s", trace)
+	}
+	for j, name := range []string{"Level", "Gold"} {
+		if v := cond.Children[j]; v.Kind != TRACE_VARIABLE || v.Name != name {
+			t.Logf("condition read of %s is wrong: %+v", name, v)
+			t.Fail()
+		}
+	}
+}
+
+func TestRollModifiers(t *testing.T) {
+	tbl := NewTable("mods")
+	tbl.AddVariable("Level", "3")
+	g := NewGroup(":Size")
+	for n, size := range []string{"tiny", "small", "medium", "large", "huge"} {
+		g.AddItem(n+1, n+1, size)
+	}
+	tbl.AddGroup(g)
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{input: "[Size=2]", expected: "small"},
+		{input: "[Size=%Level%]", expected: "medium"},
+		{input: "[Size=%Level%+1]", expected: "large"},
+		{input: "[Size={Abs~-4}]", expected: "large"},
+		{input: "[Size=9]", expected: "huge"},
+		{input: "[Size+10]", expected: "huge"},
+		{input: "[Size-%Level%0]", expected: "tiny"},
+		{input: "[Size+(2*%Level%)]", expected: "huge"},
+	}
+
+	for tcase, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			res := tbl.Evaluate(tt.input)
+			if res != tt.expected {
+				t.Logf("Case %d: %s wanted %s, have %s", tcase, tt.input, tt.expected, res)
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestForeignRoll(t *testing.T) {
+	err := LoadAllTables("../testdata/Tables")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl := NewTable("caller")
+	g := NewGroup(":Start")
+	g.AddItem(1, 1, "x")
+	tbl.AddGroup(g)
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{input: "[Functions.Caps]", expected: "LOWER CASE"},
+		{input: "[functions.Lower]", expected: "upper case"},
+		{input: "[CN]", expected: "Violl’s Garden"},
+		{input: "[CN.Suffix=3]", expected: "mont"},
+		{input: "[Nowhere]", expected: "\n--ERROR Rolling Group-- [Nowhere]: no group or table named Nowhere\n"},
+		{input: "[Nowhere.Start]", expected: "\n--ERROR Rolling Group-- [Nowhere.Start]: no table named Nowhere\n"},
+		{input: "[Functions.Nothing]", expected: "\n--ERROR Rolling Group-- [Functions.Nothing]: table functions has no group named Nothing\n"},
+	}
+
+	for tcase, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			res := tbl.Evaluate(tt.input)
+			if res != tt.expected {
+				t.Logf("Case %d: %s wanted %q, have %q", tcase, tt.input, tt.expected, res)
+				t.Fail()
+			}
+		})
+	}
+
+	// rolls on the other table are recorded in the callers trace
+	session := NewSession()
+	session.StartTrace(tbl.Name, "Start")
+	session.Table(tbl).Evaluate("[Functions.Caps]")
+	trace := session.StopTrace()
+	if len(trace.Children) != 1 || trace.Children[0].Table != "functions" {
+		t.Logf("foreign roll missing from trace\n%s", trace)
+		t.Fail()
+	}
+}
+
+func TestInlineAssign(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{input: "|X=3|%X%", expected: "3"},
+		{input: "|X+2|%X%", expected: "6"},
+		{input: "|X-1|%X%", expected: "3"},
+		{input: "|X*5|%X%", expected: "20"},
+		{input: "|X/8|%X%", expected: "0.5"},
+		{input: "|X=7||X\\2|%X%", expected: "3"},
+		{input: "|X>2|%X% |X>9|%X%", expected: "4 9"},
+		{input: "|X<12|%X% |X<1|%X%", expected: "4 1"},
+		{input: "|Name=Grim||Name&wald|%Name%", expected: "Grimwald"},
+		{input: "hexagonal|Temp={Ceil~{Calc~(%X%*0.9)}}||X=%Temp%| %X%", expected: "hexagonal 4"},
+		{input: "a|b|c", expected: "a|b|c"},
+		{input: "no end |X=2", expected: "no end |X=2"},
+		{input: "{OrderAsc~\"|\",b|a}", expected: "a|b"},
+	}
+
+	for tcase, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			tbl := NewTable("assign")
+			tbl.AddVariable("X", "4")
+			res := tbl.Evaluate(tt.input)
+			if res != tt.expected {
+				t.Logf("Case %d: %s wanted %q, have %q", tcase, tt.input, tt.expected, res)
+				t.Fail()
+			}
+		})
+	}
+
+	// later text in the same generation sees the new value
+	tbl := NewTable("assign")
+	tbl.AddVariable("Gold", "10")
+	g := NewGroup(":Start")
+	g.AddItem(1, 1, "[Loot] %Gold% gold")
+	tbl.AddGroup(g)
+	g = NewGroup(":Loot")
+	g.AddItem(1, 1, "a chest|Gold+25|")
+	tbl.AddGroup(g)
+	res, trace := tbl.RollTrace("Start")
+	if res != "a chest 35 gold" {
+		t.Logf("wanted 'a chest 35 gold' have %q", res)
+		t.Fail()
+	}
+	loot := trace.Children[0].Children[0]
+	if len(loot.Children) != 1 || loot.Children[0].Kind != TRACE_ASSIGN || loot.Children[0].Result != "35" {
+		t.Logf("assignment missing from trace\n%s", trace)
+		t.Fail()
+	}
+}
@@ -0,0 +1,207 @@
+package tables
+
+/*
+ * Table parameters let one table serve many uses, e.g. a Monster
+ * table for every terrain and level. Parameters are declared
+ * in the table file with an @ line;
+ *
+ *   @Name,Default,Prompt text[,Choice1,Choice2...]
+ *
+ * a parameter is a variable, %Name%, set to its default unless
+ * an argument is passed, [Monster.Start(Swamp,3)] or from the
+ * command line, rtbl new "Monster(Level=3)"
+ * when there are choices the argument must be one of them, or
+ * the number of one, 1 for the first
+ */
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type Param struct {
+	Name    string
+	Default string
+	Prompt  string
+	Choices []string // empty when any value is allowed
+}
+
+func (p *Param) String() string {
+	s := fmt.Sprintf("%s: %s", p.Name, p.Prompt)
+	if len(p.Choices) == 0 {
+		return s + fmt.Sprintf(" (%s)", p.Default)
+	}
+	var choices []string
+	for _, c := range p.Choices {
+		if c == p.Default {
+			c += "*"
+		}
+		choices = append(choices, c)
+	}
+	return s + " [" + strings.Join(choices, ", ") + "]"
+}
+
+// the value of the parameter for an argument, an empty
+// argument is the default
+func (p *Param) value(arg string) (string, error) {
+	arg = strings.TrimSpace(arg)
+	if arg == "" {
+		return p.Default, nil
+	}
+	if len(p.Choices) == 0 {
+		return arg, nil
+	}
+	for _, c := range p.Choices {
+		if strings.EqualFold(c, arg) {
+			return c, nil
+		}
+	}
+	n, err := strconv.Atoi(arg)
+	if err == nil && n >= 1 && n <= len(p.Choices) {
+		return p.Choices[n-1], nil
+	}
+	return "", fmt.Errorf("%s is not a choice for parameter %s, use one of %s",
+		arg, p.Name, strings.Join(p.Choices, ", "))
+}
+
+func parseParamDeclaration(line string) (*Param, error) {
+	// Parameter Format: @Name,Default,Prompt,Choice1,Choice2...
+	words := strings.Split(line[1:], ",")
+	for j := range words {
+		words[j] = strings.TrimSpace(words[j])
+	}
+	p := &Param{Name: words[0]}
+	if p.Name == "" || strings.ContainsAny(p.Name, " \t%[]{}|=") {
+		return nil, fmt.Errorf("Parameter name is missing or not a word; %s", line)
+	}
+	if len(words) > 1 {
+		p.Default = words[1]
+	}
+	if len(words) > 2 {
+		p.Prompt = words[2]
+	}
+	if len(words) > 3 {
+		p.Choices = words[3:]
+		def, err := p.value(p.Default)
+		if err != nil {
+			return nil, fmt.Errorf("Parameter default %s is not a choice; %s", p.Default, line)
+		}
+		p.Default = def
+	}
+	return p, nil
+}
+
+// add a parameter to the table, its variable is set to the default
+// a parameter with the same name is replaced and reported as an error
+func (t *Table) AddParam(p *Param) error {
+	_, exists := t.GetParam(p.Name)
+	if exists {
+		for j, q := range t.Params {
+			if q.Name == p.Name {
+				t.Params[j] = p
+			}
+		}
+	} else {
+		t.Params = append(t.Params, p)
+	}
+	t.AddVariable(p.Name, p.Default)
+	if exists {
+		return fmt.Errorf("table %s already has a parameter named %s", t.Name, p.Name)
+	}
+	return nil
+}
+
+func (t *Table) GetParam(name string) (*Param, bool) {
+	for _, p := range t.Params {
+		if strings.EqualFold(p.Name, name) {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// BindArgs sets the table parameters from a list of arguments,
+// either positional, in the order the parameters are declared,
+// or named, Name=value. Parameters not given an argument are
+// set to their default. An argument is literal text, only the
+// default, declared in the table, is evaluated
+func (t *Table) BindArgs(args []string) error {
+	return t.BindArgsEscaped(args, nil)
+}
+
+// BindArgsEscaped is BindArgs with each argument passed through
+// escape once it is checked against the choices of its parameter,
+// e.g. html.EscapeString for arguments from a request
+func (t *Table) BindArgsEscaped(args []string, escape func(string) string) error {
+	values := make(map[string]string)
+	pos := 0
+	for _, arg := range args {
+		if idx := strings.Index(arg, "="); idx != -1 {
+			if p, ok := t.GetParam(strings.TrimSpace(arg[:idx])); ok {
+				v, err := p.value(arg[idx+1:])
+				if err != nil {
+					return err
+				}
+				values[p.Name] = v
+				continue
+			}
+		}
+		if pos >= len(t.Params) {
+			return fmt.Errorf("table %s has %d parameters, too many arguments: %s",
+				t.Name, len(t.Params), strings.Join(args, ","))
+		}
+		p := t.Params[pos]
+		pos++
+		v, err := p.value(arg)
+		if err != nil {
+			return err
+		}
+		values[p.Name] = v
+	}
+	for _, p := range t.Params {
+		if v, ok := values[p.Name]; ok {
+			if escape != nil {
+				v = escape(v)
+			}
+			t.SetVariable(p.Name, v)
+		} else {
+			t.AddVariable(p.Name, p.Default)
+		}
+	}
+	return nil
+}
+
+// keep the parameter values, a call with arguments only sets
+// them for that call, the returned func puts them back
+func (t *Table) saveParams() func() {
+	saved := make(map[string]Variable)
+	for _, p := range t.Params {
+		saved[p.Name] = t.Variables[p.Name]
+	}
+	return func() {
+		for name, v := range saved {
+			t.setVariable(name, v)
+		}
+	}
+}
+
+// split the arguments from a group reference,
+// e.g. Monster.Start(Swamp,3)+2 is Monster.Start+2 and Swamp, 3
+// parens after a roll modifier, Size+(2*3), are an expression
+// and are left alone
+func splitArgs(ref string) (string, []string, bool) {
+	i := strings.Index(ref, "(")
+	if i < 1 || strings.ContainsAny(ref[:i], "=+-") {
+		return ref, nil, false
+	}
+	sub, last := findEndDelim(ref[i+1:], "(", ")")
+	rest := ref[:i]
+	if end := i + 1 + last + 1; end < len(ref) {
+		rest += ref[end:]
+	}
+	if strings.TrimSpace(sub) == "" {
+		return rest, nil, true
+	}
+	return rest, strings.Split(sub, ","), true
+}
@@ -0,0 +1,147 @@
+package tables
+
+/*
+ * Test table parameters, see params.go
+ */
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseParamDeclaration(t *testing.T) {
+	tests := []struct {
+		input    string
+		name     string
+		def      string
+		prompt   string
+		choices  int
+		hasError bool
+	}{
+		{input: "@Level,1,Party level", name: "Level", def: "1", prompt: "Party level"},
+		{input: "@Terrain,Swamp,Terrain,Forest,Desert,Swamp", name: "Terrain", def: "Swamp", prompt: "Terrain", choices: 3},
+		{input: "@Terrain,2,Terrain,Forest,Desert,Swamp", name: "Terrain", def: "Desert", prompt: "Terrain", choices: 3},
+		{input: "@Name", name: "Name"},
+		{input: "@Terrain,Ocean,Terrain,Forest,Desert", hasError: true},
+		{input: "@,1,No name", hasError: true},
+	}
+
+	for tcase, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			p, err := parseParamDeclaration(tt.input)
+			if tt.hasError {
+				if err == nil {
+					t.Logf("Case %d: %s wanted an error", tcase, tt.input)
+					t.Fail()
+				}
+				return
+			}
+			if err != nil || p.Name != tt.name || p.Default != tt.def ||
+				p.Prompt != tt.prompt || len(p.Choices) != tt.choices {
+				t.Logf("Case %d: %s wrong parameter %+v %v", tcase, tt.input, p, err)
+				t.Fail()
+			}
+		})
+	}
+}
+
+// a table whose result shows its parameters
+func makeParamTable() *Table {
+	tbl := NewTable("monster")
+	p, _ := parseParamDeclaration("@Terrain,Forest,Terrain,Forest,Desert,Swamp")
+	tbl.AddParam(p)
+	p, _ = parseParamDeclaration("@Level,1,Party level")
+	tbl.AddParam(p)
+	g := NewGroup(":Start")
+	g.AddItem(1, 1, "%Terrain% %Level%")
+	tbl.AddGroup(g)
+	g = NewGroup(":Nested")
+	g.AddItem(1, 1, "[Start(Swamp,%Level%0)] %Terrain%")
+	tbl.AddGroup(g)
+	return tbl
+}
+
+func TestBindArgs(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected string
+		hasError bool
+	}{
+		{args: nil, expected: "Forest 1"},
+		{args: []string{"Desert", "4"}, expected: "Desert 4"},
+		{args: []string{"swamp"}, expected: "Swamp 1"},
+		{args: []string{"3"}, expected: "Swamp 1"},
+		{args: []string{"Level=5"}, expected: "Forest 5"},
+		{args: []string{"level=5", "Desert"}, expected: "Desert 5"},
+		{args: []string{"", "2"}, expected: "Forest 2"},
+		{args: []string{"Ocean"}, hasError: true},
+		{args: []string{"Desert", "4", "extra"}, hasError: true},
+	}
+
+	for tcase, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			tbl := makeParamTable()
+			err := tbl.BindArgs(tt.args)
+			if tt.hasError {
+				if err == nil {
+					t.Logf("Case %d: %v wanted an error", tcase, tt.args)
+					t.Fail()
+				}
+				return
+			}
+			res := tbl.Roll("Start")
+			if err != nil || res != tt.expected {
+				t.Logf("Case %d: %v wanted %s, have %s %v", tcase, tt.args, tt.expected, res, err)
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestRollArgs(t *testing.T) {
+	tbl := makeParamTable()
+
+	// arguments only hold for the call they are passed to
+	res := tbl.Roll("Nested")
+	if res != "Swamp 10 Forest" {
+		t.Logf("wanted 'Swamp 10 Forest' have '%s'", res)
+		t.Fail()
+	}
+	res = tbl.Evaluate("[Start(Jungle)]")
+	if res != "\n--ERROR Rolling Group-- [Start]: Jungle is not a choice for parameter Terrain, use one of Forest, Desert, Swamp\n" {
+		t.Logf("wrong error for a bad choice %q", res)
+		t.Fail()
+	}
+	// an argument is text, it is not evaluated again
+	tbl.BindArgs([]string{"Level={Dice~1d100}|Level=1|"})
+	if res := tbl.Roll("Start"); res != "Forest {Dice~1d100}|Level=1|" {
+		t.Logf("bound argument evaluated as markup %q", res)
+		t.Fail()
+	}
+	if res := tbl.Evaluate("[Start(Desert,%Level%)]"); res != "Desert {Dice~1d100}|Level=1|" {
+		t.Logf("nested argument evaluated twice %q", res)
+		t.Fail()
+	}
+	tbl.BindArgs(nil)
+
+	// parens after a modifier are an expression, not arguments
+	if ref, args, ok := splitArgs("Size+(2*3)"); ok || ref != "Size+(2*3)" || args != nil {
+		t.Logf("modifier expression split as arguments %s %v", ref, args)
+		t.Fail()
+	}
+
+	// and passed to another table, from a nested call
+	root := t.TempDir()
+	content := "@Terrain,Forest,Terrain,Forest,Desert,Swamp\n:Start\n1,%Terrain%\n"
+	if err := os.WriteFile(filepath.Join(root, "Monster.tab"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadAllTables(root); err != nil {
+		t.Fatal(err)
+	}
+	res = tbl.Evaluate("[Monster(Desert)] [Monster.Start(Terrain=3)] [Monster]")
+	if res != "Desert Swamp Forest" {
+		t.Logf("wanted 'Desert Swamp Forest' have '%s'", res)
+		t.Fail()
+	}
+}
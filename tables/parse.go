@@ -7,7 +7,10 @@ package tables
  */
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
+	"path/filepath"
 	"rtbl/stringsext"
 	"rtbl/tfs"
 	"strconv"
@@ -21,63 +24,112 @@ const (
 	SEMI_GROUP
 )
 
+// an error at an offset of the parsed line, so its diagnostic
+// can point at the bad part rather than the start of the line
+type offsetError struct {
+	offset int
+	err    error
+}
+
+func (e *offsetError) Error() string { return e.err.Error() }
+func (e *offsetError) Unwrap() error { return e.err }
+
+// the offset of an error in its line, 0 when it has none
+func errorOffset(err error) int {
+	var oe *offsetError
+	if errors.As(err, &oe) {
+		return oe.offset
+	}
+	return 0
+}
+
 // Aboslute Tables start with a colon (:)
 // each entry starts with absolute numeric range
-// a range may be a single digit
-func parseColonItem(line string) (int, int, string, error) {
+// a range may be a single digit, see parseRange
+func parseColonItem(line string) (int, int, bool, string, error) {
 	/* Parse these;
 	 * 1-2,Orc
 	 * 3,Skeleton
 	 * 4-7,Archdaemon
 	 * 8,[Goblins.Start]
 	 * 9,[Color] Spirit
+	 * 95+,Dragon
 	 */
-	var err error
-	var min int64
-	var max int64
-
-	var irest int
-
 	idx := strings.Index(line, ",")
 	if idx == -1 { // comma not found, lets check for a tab
 		idx = strings.Index(line, "\t")
 		if idx == -1 {
-			return int(0), int(0), "",
-				fmt.Errorf("Colon Group: no delimiter between range and text; %s", line)
+			return 0, 0, false, "", &offsetError{len(line),
+				fmt.Errorf("Colon Group: no delimiter between range and text; %s", line)}
 		}
 	}
-	fields := []string{line[:idx], line[idx+1:]}
-	// fields[0] is the number range and needs to be parsed
-	//   is there a dash??
-	nums := strings.Split(fields[0], "-")
-	irest = len(fields[0]) + 1
-	if len(nums) == 1 {
-		// single number, not a range
-		min, err = strconv.ParseInt(nums[0], 10, 0)
-		if err != nil {
-			return int(0), int(0), "",
-				fmt.Errorf("Colon Group: single probability is not a number; %s", line)
-		}
-	} else if len(nums) == 2 {
-		// probability range
-		min, err = strconv.ParseInt(nums[0], 10, 0)
+	start, end, open, err := parseRange(line[:idx])
+	if err != nil {
+		return 0, 0, false, "", &offsetError{errorOffset(err),
+			fmt.Errorf("Colon Group: %s; %s", err, line)}
+	}
+	return start, end, open, line[idx+1:], nil
+}
+
+// parse the range of a colon group entry
+//
+//	5      a single number
+//	1-4    from 1 to 4
+//	-3--1  negative numbers, for modified rolls
+//	95+    95 and above, modified rolls beyond the die land here
+//	96-00  00 is 100, for percentile tables, 000 is 1000
+//
+// errors carry the offset in s of the bad bound
+func parseRange(s string) (start, end int, open bool, err error) {
+	lead := len(s) - len(strings.TrimLeft(s, " \t"))
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, 0, false, &offsetError{lead, fmt.Errorf("no range before the text")}
+	}
+	if strings.HasSuffix(s, "+") {
+		start, err = parseRangeBound(s[:len(s)-1])
 		if err != nil {
-			return int(0), int(0), "",
-				fmt.Errorf("Colon Group: min probability of range is not a number; %s", line)
+			return 0, 0, false, &offsetError{lead,
+				fmt.Errorf("open ended range %s must be a number then +, e.g. 95+", s)}
 		}
-		max, err = strconv.ParseInt(nums[1], 10, 0)
+		return start, start, true, nil
+	}
+	// the dash between the numbers, a leading dash is a minus
+	idx := strings.Index(s[1:], "-")
+	if idx == -1 {
+		start, err = parseRangeBound(s)
 		if err != nil {
-			return int(0), int(0), "",
-				fmt.Errorf("Colon Group: max probability of range is not a number; %s", line)
+			return 0, 0, false, &offsetError{lead, fmt.Errorf("single probability is not a number")}
 		}
+		return start, start, false, nil
+	}
+	idx++
+	start, err = parseRangeBound(s[:idx])
+	if err != nil {
+		return 0, 0, false, &offsetError{lead, fmt.Errorf("min probability of range is not a number")}
+	}
+	end, err = parseRangeBound(s[idx+1:])
+	if err != nil {
+		return 0, 0, false, &offsetError{lead + idx + 1, fmt.Errorf("max probability of range is not a number")}
 	}
-	if max != 0 && max < min {
-		return int(0), int(0), "",
-			fmt.Errorf("Colon Group: max of probability range is less than min; %s", line)
+	if end < start {
+		return 0, 0, false, &offsetError{lead + idx + 1, fmt.Errorf("max of probability range is less than min")}
 	}
-	// fields[1] is the text
+	return start, end, false, nil
+}
 
-	return int(min), int(max), line[irest:], nil
+// a number of a range, 00 is 100 and 000 is 1000
+func parseRangeBound(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if len(s) > 1 && strings.Trim(s, "0") == "" {
+		n := 1
+		for range s {
+			n *= 10
+		}
+		return n, nil
+	}
+	n, err := strconv.ParseInt(s, 10, 0)
+	return int(n), err
 }
 
 // relative tables start with a semi-colon(;)
@@ -95,28 +147,33 @@ func parseSemiItem(line string) (int, string, error) {
 	if idx == -1 {
 		idx = strings.Index(line, "\t")
 		if idx == -1 {
-			return int(0), "",
-				fmt.Errorf("Semi Group: no delimiter between range and text; %s", line)
+			return int(0), "", &offsetError{len(line),
+				fmt.Errorf("Semi Group: no delimiter between range and text; %s", line)}
 		}
 	}
 	fields := []string{line[:idx], line[idx+1:]}
 	min, err = strconv.ParseInt(fields[0], 10, 0)
 	if err != nil {
-		return int(0), "",
-			fmt.Errorf("Semi Group: probability is not a number; %s", line)
+		return int(0), "", &offsetError{0,
+			fmt.Errorf("Semi Group: probability is not a number; %s", line)}
 	}
 	return int(min), fields[1], nil
 }
 
-func parseVariableDeclaration(line string) (string, string, error) {
-	// Variable Format: %VariableName%,x
-	words := strings.Split(line, ",")
-	words[0] = strings.Replace(words[0], "%", "", -1)
-	value := ""
-	if len(words) == 2 {
-		value = words[1]
-	}
-	return words[0], value, nil
+// Variable Format: %VariableName%,x
+// a macro, evaluated each time it is read, is %~VariableName%,x
+func parseVariableDeclaration(line string) (string, string, bool, error) {
+	name, value := line, ""
+	if idx := strings.Index(line, ","); idx != -1 {
+		name, value = line[:idx], line[idx+1:]
+	}
+	name = strings.Replace(name, "%", "", -1)
+	macro := strings.HasPrefix(name, "~")
+	name = strings.TrimPrefix(name, "~")
+	if name == "" {
+		return "", "", false, fmt.Errorf("Variable has no name %s", line)
+	}
+	return name, value, macro, nil
 }
 
 func parseVariableAssignment(line string) (string, string, string, error) {
@@ -138,38 +195,126 @@ func parseVariableAssignment(line string) (string, string, string, error) {
 }
 
 // read a table file and parse to create the Table struct.
-// All tables are stored in the TableRegistry
+// All tables are stored in the TableRegistry, the name may be
+// a full name such as Names.Greek or a short one, Greek
+// when the table has errors they are all returned as Diagnostics,
+// warnings are kept in Table.Diagnostics
 func Parse(tableName string) (*Table, error) {
-	errorFmt := "Error: %s, line %d\n"
-	tableName = strings.ToLower(tableName)
-
 	//check the table registry to see if the table has
 	// already been loaded
-	loadedTable, ok := TableRegistry[tableName]
-	if !ok {
-		return nil, fmt.Errorf("No table found")
+	return TableRegistry.Parse(tableName)
+}
+
+// Parse the table file, once, the parsed table is kept
+// for the next call. Parse may be called from many goroutines,
+// the others wait for the first to finish parsing
+func (loadedTable *LoadedTable) Parse() (*Table, error) {
+	loadedTable.once.Do(func() {
+		loadedTable.table, loadedTable.err = loadedTable.parse()
+	})
+	return loadedTable.table, loadedTable.err
+}
+
+func (loadedTable *LoadedTable) parse() (*Table, error) {
+	content, err := readLines(loadedTable.fsys, loadedTable.path)
+	if err != nil {
+		return nil, err
+	}
+
+	table := parseLines(loadedTable.name, loadedTable.path, content)
+	if r := loadedTable.registry; r != nil && r.diagnose != nil {
+		for _, d := range table.Diagnostics {
+			r.diagnose(d)
+		}
 	}
-	if loadedTable.table != nil {
-		return loadedTable.table, nil
+	if table.Diagnostics.HasErrors() {
+		return nil, table.Diagnostics
 	}
+	table.registry = loadedTable.registry
+	return table, nil
+}
 
-	// if not already loaded, lets load it and parse  it
-	content, err := tfs.ReadFile(loadedTable.path)
+// read the lines of a table file from fsys, or the OS when nil
+func readLines(fsys fs.FS, path string) ([]string, error) {
+	if fsys == nil {
+		return tfs.ReadFile(path)
+	}
+	content, err := fs.ReadFile(fsys, path)
 	if err != nil {
 		return nil, err
 	}
+	lines := strings.Split(string(content), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines, nil
+}
 
-	// Let see if we have already parse this table
+// ParseFile parses a table file that need not be in the TableRegistry,
+// the table is returned even when it has errors so every
+// Diagnostic can be reported
+func ParseFile(path string) (*Table, Diagnostics) {
+	name := strings.ToLower(strings.TrimSuffix(filepath.Base(path), ".tab"))
+	content, err := tfs.ReadFile(path)
+	if err != nil {
+		var ds Diagnostics
+		ds.add(SEVERITY_ERROR, path, 0, 0, "%s", err)
+		return nil, ds
+	}
+	table := parseLines(name, path, content)
+	return table, table.Diagnostics
+}
+
+// a group from its header line, which may end with the
+// roll of the group, e.g. :Reaction=2d6
+func newParsedGroup(line string, errorf func(string, ...interface{})) *Group {
+	spec := ""
+	if idx := strings.LastIndex(line, "="); idx > 0 && isGroupDice(line[idx+1:]) {
+		line, spec = line[:idx], line[idx+1:]
+	}
+	group := NewGroup(line)
+	if spec != "" {
+		if err := group.SetDice(spec); err != nil {
+			errorf("%s", err)
+		}
+	}
+	return group
+}
+
+// parse the lines of a table file, recording problems in
+// table.Diagnostics and carrying on with the next line
+func parseLines(tableName, path string, content []string) *Table {
 	table := NewTable(tableName)
 	table.Size = len(content)
-	table.Path = loadedTable.path // TODO refactor path away. dont need here and in loadedTable
+	table.Path = path // TODO refactor path away. dont need here and in loadedTable
 	var group *Group
+	var groupLine int
 	var state int
 
+	diags := &table.Diagnostics
+	// column is where the trimmed line starts in the file
+	var lineno, column int
+	errorf := func(format string, args ...interface{}) {
+		diags.add(SEVERITY_ERROR, path, lineno+1, column, format, args...)
+	}
+	warnf := func(format string, args ...interface{}) {
+		diags.add(SEVERITY_WARNING, path, lineno+1, column, format, args...)
+	}
+	// an error of an item, at the part of the line it points to
+	errorAt := func(err error) {
+		diags.add(SEVERITY_ERROR, path, lineno+1, column+errorOffset(err), "%s", err)
+	}
+	addGroup := func() {
+		if err := table.AddGroup(group); err != nil {
+			diags.add(SEVERITY_WARNING, path, groupLine+1, 1, "%s, only the last one is used", err)
+		}
+	}
+
 	//  .-------------.
 	//  | Parse Lines |
 	//  '-------------'
-	for lineno, line := range content {
+	var line string
+	for lineno, line = range content {
 		// Check for comment and strip it
 		idx := strings.Index(line, "#")
 		if idx > -1 {
@@ -177,7 +322,9 @@ func Parse(tableName string) (*Table, error) {
 		}
 		// trim the line
 		line = strings.TrimRight(line, "\t\r\n")
-		line = strings.TrimLeft(line, " \t")
+		trimmed := strings.TrimLeft(line, " \t")
+		column = len(line) - len(trimmed) + 1
+		line = trimmed
 		// if there is nothing to parse go to next line
 		// blank line also closes any previous group parsing
 		if len(line) == 0 {
@@ -195,36 +342,48 @@ func Parse(tableName string) (*Table, error) {
 			// can be implemented in someway
 			switch directive {
 			case "BackColor":
-				fmt.Printf("Unknown directive, ignoring %s, line %d\n", line, lineno)
+				warnf("Unknown directive, ignoring %s", line)
 			case "Background":
-				fmt.Printf("Unknown directive, ignoring %s, line %d\n", line, lineno)
+				warnf("Unknown directive, ignoring %s", line)
 			case "OutputFooter":
 				table.Footer = stringsext.Rest(line, " ")
 			case "OutputHeader":
 				table.Header = stringsext.Rest(line, " ")
 			case "OverrideRolls":
-				fmt.Printf("Unknown directive, ignoring %s, line %d\n", line, lineno)
+				warnf("Unknown directive, ignoring %s", line)
 			case "Stylesheet":
-				fmt.Printf("Unknown directive, ignoring %s, line %d\n", line, lineno)
+				warnf("Unknown directive, ignoring %s", line)
 			default:
-				fmt.Printf("Unknown directive, ignoring %s, line %d\n", line, lineno)
+				warnf("Unknown directive, ignoring %s", line)
+			}
+		} else if line[0] == '@' {
+			// Parameter Format: @Name,Default,Prompt,Choice1,Choice2...
+			param, err := parseParamDeclaration(line)
+			if err != nil {
+				errorf("%s", err)
+				continue
+			}
+			if err := table.AddParam(param); err != nil {
+				warnf("%s, only the last one is used", err)
 			}
 		} else if line[0] == ':' {
 			state = COLON_GROUP
 			// Save any previous group
 			if group != nil {
-				table.AddGroup(group)
+				addGroup()
 				group = nil
 			}
-			group = NewGroup(line)
+			group = newParsedGroup(line, errorf)
+			groupLine = lineno
 		} else if line[0] == ';' {
 			state = SEMI_GROUP
 			// Save any previous group
 			if group != nil {
-				table.AddGroup(group)
+				addGroup()
 				group = nil
 			}
-			group = NewGroup(line)
+			group = newParsedGroup(line, errorf)
+			groupLine = lineno
 		} else if state == COLON_GROUP {
 			if line[0] == '<' {
 				// is it a prefix?
@@ -236,20 +395,19 @@ func Parse(tableName string) (*Table, error) {
 				// is it continuation
 				err := group.AppendLastItem("<br>" + line[1:])
 				if err != nil {
-					return nil, fmt.Errorf(errorFmt, err, lineno)
+					errorf("%s", err)
 				}
 			} else {
-				start, end, text, err := parseColonItem(line)
-				// for single number 'ranges' end will be == 0
-				// to complete teh range we wil set it equal to
-				// start
-				if end == 0 {
-					end = start
-				}
+				start, end, open, text, err := parseColonItem(line)
 				if err != nil {
-					return nil, fmt.Errorf(errorFmt, err, lineno)
+					errorAt(err)
+					continue
+				}
+				if open {
+					group.AddOpenItem(start, text)
+				} else {
+					group.AddItem(start, end, text)
 				}
-				group.AddItem(start, end, text)
 			}
 		} else if state == SEMI_GROUP {
 			if line[0] == '<' {
@@ -262,28 +420,31 @@ func Parse(tableName string) (*Table, error) {
 				// is it continuation
 				err := group.AppendLastItem("<br>" + line[1:])
 				if err != nil {
-					return nil, fmt.Errorf(errorFmt, err, lineno)
+					errorf("%s", err)
 				}
 			} else {
 				num, text, err := parseSemiItem(line)
 				if err != nil {
-					return nil, fmt.Errorf(errorFmt, err, lineno)
+					errorAt(err)
 				} else {
 					group.AddItem(num, 0, text)
 				}
 			}
 		} else if line[0] == '%' {
 			// Variable Format: %VariableName%,x
-			name, value, err := parseVariableDeclaration(line)
+			name, value, macro, err := parseVariableDeclaration(line)
 			if err != nil {
-				err = fmt.Errorf(errorFmt, err, lineno)
-				return nil, err
+				errorf("%s", err)
+				continue
 			}
 
-			err = table.AddVariable(name, value)
+			if macro {
+				err = table.AddMacro(name, value)
+			} else {
+				err = table.AddVariable(name, value)
+			}
 			if err != nil {
-				err = fmt.Errorf(errorFmt, err, lineno)
-				return nil, err
+				errorf("%s", err)
 			}
 		} else if line[0] == '|' {
 			// Variable Format: |VariableName?x|
@@ -291,56 +452,21 @@ func Parse(tableName string) (*Table, error) {
 
 			name, newstr, op, err := parseVariableAssignment(line)
 			if err != nil {
-				err = fmt.Errorf(errorFmt, err, lineno)
-				return nil, err
+				errorf("%s", err)
+				continue
 			}
 
-			oldstr, ok := table.GetVariable(name)
-			var oldval float64
-			var newval float64
-			if ok {
-				oldval, _ = strconv.ParseFloat(oldstr, 32)
-			} else {
-				oldval = 0.0
-			}
-			newval, _ = strconv.ParseFloat(newstr, 64)
-			// process the op to create the new string value
-			// that wiil be stored under the variable 'name'
-			switch op {
-			case "+":
-				newstr = fmt.Sprintf("%f", oldval+newval)
-			case "-":
-				newstr = fmt.Sprintf("%f", oldval-newval)
-			case "*":
-				newstr = fmt.Sprintf("%f", oldval*newval)
-			case "/":
-				newstr = fmt.Sprintf("%f", oldval/newval)
-			case "\\":
-				newstr = fmt.Sprintf("%d", int(oldval/newval))
-			case ">":
-				if newval <= oldval {
-					newstr = oldstr // re-assign old value to variable
-				}
-			case "<":
-				if newval >= oldval {
-					newstr = oldstr
-				}
-			case "&":
-				// string catenation
-				newstr = oldstr + newstr
-			case "=":
-				// noop, this will just assign newstr to the variable
-			default:
-				return nil, fmt.Errorf("Unknown OpCode in %s", line)
+			_, err = table.AssignVariable(name, op, newstr)
+			if err != nil {
+				errorf("%s in %s", err, line)
 			}
-			// will add or update variable
-			table.AddVariable(name, newstr)
+		} else {
+			warnf("text outside of a group is ignored; %s", line)
 		}
 	}
 	if group != nil {
-		table.AddGroup(group)
+		addGroup()
 		group = nil
 	}
-	loadedTable.table = table
-	return table, nil
+	return table
 }
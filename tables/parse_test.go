@@ -0,0 +1,178 @@
+package tables
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseDiagnostics(t *testing.T) {
+	content := []string{
+		"/Stylesheet style.css",
+		":Start",
+		"1,[Color]",
+		"  x-2,bad min",
+		"  1-x,bad max",
+		"3",
+		":Color",
+		"1,red",
+		"|Za!4|",
+		":Color",
+		"1,blue",
+		";Mood",
+		"\t2",
+	}
+	tbl := parseLines("diag", "diag.tab", content)
+
+	expected := []string{
+		"diag.tab:1:1: warning: Unknown directive, ignoring /Stylesheet style.css",
+		"diag.tab:4:3: error: Colon Group: min probability of range is not a number; x-2,bad min",
+		"diag.tab:5:5: error: Colon Group: max probability of range is not a number; 1-x,bad max",
+		"diag.tab:6:2: error: Colon Group: no delimiter between range and text; 3",
+		"diag.tab:9:7: error: Colon Group: no delimiter between range and text; |Za!4|",
+		"diag.tab:10:1: warning: table diag already has a group named Color, only the last one is used",
+		"diag.tab:13:3: error: Semi Group: no delimiter between range and text; 2",
+	}
+	have := strings.Split(tbl.Diagnostics.Error(), "\n")
+	if strings.Join(have, "\n") != strings.Join(expected, "\n") {
+		t.Logf("wanted:\n%s\nhave:\n%s", strings.Join(expected, "\n"), strings.Join(have, "\n"))
+		t.Fail()
+	}
+	if !tbl.Diagnostics.HasErrors() {
+		t.Log("HasErrors is false")
+		t.Fail()
+	}
+	// the good lines are still parsed
+	if tbl.Groups["Start"].Len() != 1 {
+		t.Log("Start group lost its good entry")
+		t.Fail()
+	}
+}
+
+func TestParseGroupDice(t *testing.T) {
+	content := []string{
+		":Reaction=2d6",
+		"2-5,hostile",
+		"6-8,wary",
+		"9-12,friendly",
+		":Events=d66",
+		"11-36,quiet",
+		"41-66,trouble",
+		":A=B",
+		"1,named A=B",
+		";Weights=2d6",
+		"1,heavy",
+		":Bad=3d1",
+		"1,never",
+	}
+	tbl := parseLines("dice", "dice.tab", content)
+	expected := []string{
+		"dice.tab:10:1: error: group Weights: only a colon group may declare its roll, 2d6",
+		"dice.tab:12:1: error: group Bad: non-euclidean die: 3d1",
+	}
+	have := strings.Split(tbl.Diagnostics.Error(), "\n")
+	if strings.Join(have, "\n") != strings.Join(expected, "\n") {
+		t.Logf("wanted:\n%s\nhave:\n%s", strings.Join(expected, "\n"), strings.Join(have, "\n"))
+		t.Fail()
+	}
+	if g := tbl.Groups["Reaction"]; g == nil || g.DiceSpec() != "2d6" || g.Min() != 2 || g.Max() != 12 {
+		t.Logf("Reaction group %+v", g)
+		t.Fail()
+	}
+	if g := tbl.Groups["Events"]; g == nil || g.Min() != 11 || g.Max() != 66 || g.Select(41) != "trouble" {
+		t.Logf("Events group %+v", g)
+		t.Fail()
+	}
+	if _, ok := tbl.Groups["A=B"]; !ok {
+		t.Log("group named A=B is a dice roll")
+		t.Fail()
+	}
+}
+
+func TestParseRanges(t *testing.T) {
+	tests := []struct {
+		input      string
+		start, end int
+		open       bool
+		err        string
+	}{
+		{input: "5", start: 5, end: 5},
+		{input: "1-4", start: 1, end: 4},
+		{input: " 2 - 3 ", start: 2, end: 3},
+		{input: "-3--1", start: -3, end: -1},
+		{input: "-2-2", start: -2, end: 2},
+		{input: "0", start: 0, end: 0},
+		{input: "-4", start: -4, end: -4},
+		{input: "95+", start: 95, end: 95, open: true},
+		{input: "-1+", start: -1, end: -1, open: true},
+		{input: "00", start: 100, end: 100},
+		{input: "96-00", start: 96, end: 100},
+		{input: "01-05", start: 1, end: 5},
+		{input: "991-000", start: 991, end: 1000},
+		{input: "", err: "no range before the text"},
+		{input: "x", err: "single probability is not a number"},
+		{input: "x-2", err: "min probability of range is not a number"},
+		{input: "1-", err: "max probability of range is not a number"},
+		{input: "1-2-3", err: "max probability of range is not a number"},
+		{input: "5-1", err: "max of probability range is less than min"},
+		{input: "-1--3", err: "max of probability range is less than min"},
+		{input: "+", err: "open ended range + must be a number then +, e.g. 95+"},
+		{input: "1-5+", err: "open ended range 1-5+ must be a number then +, e.g. 95+"},
+	}
+	for _, tt := range tests {
+		start, end, open, err := parseRange(tt.input)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Logf("%q wanted error %q, have %v", tt.input, tt.err, err)
+				t.Fail()
+			}
+			continue
+		}
+		if err != nil || start != tt.start || end != tt.end || open != tt.open {
+			t.Logf("%q wanted %d %d %v, have %d %d %v %v",
+				tt.input, tt.start, tt.end, tt.open, start, end, open, err)
+			t.Fail()
+		}
+	}
+
+	content := []string{
+		":Start",
+		"1,[Loot]",
+		":Loot",
+		"-3--1,cursed",
+		"0,nothing",
+		"01-95,coins",
+		"96-00,a gem",
+		"101+,a crown",
+	}
+	tbl := parseLines("pct", "pct.tab", content)
+	if tbl.Diagnostics != nil {
+		t.Fatal(tbl.Diagnostics)
+	}
+	if g := tbl.Groups["Loot"]; g.Max() != 101 || g.DiceSpec() != "1d101" {
+		t.Logf("Loot rolls %s", g.DiceSpec())
+		t.Fail()
+	}
+	rolls := []struct {
+		input    string
+		expected string
+	}{
+		{input: "[Loot=-2]", expected: "cursed"},
+		{input: "[Loot=-20]", expected: "cursed"},
+		{input: "[Loot=0]", expected: "nothing"},
+		{input: "[Loot=100]", expected: "a gem"},
+		{input: "[Loot=250]", expected: "a crown"},
+		{input: "[Loot+500]", expected: "a crown"},
+		{input: "[Loot-500]", expected: "cursed"},
+	}
+	for _, tt := range rolls {
+		if res := tbl.Evaluate(tt.input); res != tt.expected {
+			t.Logf("%s wanted %s, have %s", tt.input, tt.expected, res)
+			t.Fail()
+		}
+	}
+	// entries only reached by modified rolls are not linted
+	for _, issue := range Lint(tbl) {
+		t.Log(issue)
+		t.Fail()
+	}
+}
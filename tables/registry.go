@@ -1 +1,228 @@
 package tables
+
+/*
+ * The TableRegistry knows every table file under the root
+ * directory. Tables are named by their path below the root
+ * with dots for slashes, e.g. Names/Greek.tab is Names.Greek,
+ * so tables of the same name in different categories do
+ * not replace each other.
+ *
+ * A table may be looked up by its full name or, when no other
+ * table shares it, by the end of its name, e.g. Greek.
+ *
+ * When two roots, or file systems, have a table of the same name
+ * the first one added is used, by every lookup, and the other is
+ * reported as a warning.
+ */
+
+import (
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type LoadedTable struct {
+	name     string // full dotted name, lower case
+	path     string
+	fsys     fs.FS // the file system path is in, nil for the OS
+	registry *Registry
+	once     sync.Once // the table is parsed once, by the first caller
+	table    *Table    // nil until the table is parsed
+	err      error     // why the table could not be parsed
+}
+
+type TablePathsByName map[string]*LoadedTable // map table name to paths
+
+type Registry struct {
+	tables   TablePathsByName          // by full dotted name, lower case
+	byBase   map[string][]*LoadedTable // by last part of the name, lower case
+	diagnose func(Diagnostic)          // given every diagnostic of a parsed table, may be nil
+}
+
+// AmbiguousTableError is returned when a short table name
+// matches tables in more than one category
+type AmbiguousTableError struct {
+	Name    string
+	Matches []string
+}
+
+func (e *AmbiguousTableError) Error() string {
+	return fmt.Sprintf("table name %s is ambiguous, use one of %s",
+		e.Name, strings.Join(e.Matches, ", "))
+}
+
+func newRegistry() *Registry {
+	return &Registry{
+		tables: make(TablePathsByName),
+		byBase: make(map[string][]*LoadedTable),
+	}
+}
+
+func NewTableList(root string, paths []string) *Registry {
+	r := newRegistry()
+	r.add(nil, root, paths)
+	return r
+}
+
+// make a map of all tables in all catagories
+// tables are paths that end in .tab
+// categories are the containing directories
+// e.g. Names/Greek.tab
+func (r *Registry) add(fsys fs.FS, root string, paths []string) {
+	for _, filepath := range paths {
+		if strings.HasSuffix(filepath, ".tab") {
+			name := makeName(filepath, root)
+			name = strings.ToLower(name) // hold all names as lower case
+			if first, exists := r.tables[name]; exists {
+				if r.diagnose != nil {
+					r.diagnose(Diagnostic{File: filepath, Severity: SEVERITY_WARNING,
+						Message: fmt.Sprintf("table %s is also %s, only that one is used", name, first.path)})
+				}
+				continue
+			}
+			lt := &LoadedTable{name: name, path: filepath, fsys: fsys, registry: r}
+			base := name[strings.LastIndex(name, ".")+1:]
+			r.byBase[base] = append(r.byBase[base], lt)
+			r.tables[name] = lt
+		}
+	}
+}
+
+// Names returns the full name of every table, sorted
+func (r *Registry) Names() []string {
+	if r == nil {
+		return nil
+	}
+	names := make([]string, 0, len(r.tables))
+	for name := range r.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Paths returns the file path of every table, sorted
+func (r *Registry) Paths() []string {
+	if r == nil {
+		return nil
+	}
+	paths := make([]string, 0, len(r.tables))
+	for _, lt := range r.tables {
+		paths = append(paths, lt.path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Lookup finds a table by name. Names are tried relative to the
+// category of the table named by from first, then its parent
+// categories, then from the root. Last, a name matching the end of
+// exactly one table name is used, e.g. Greek for Names.Greek
+func (r *Registry) Lookup(name, from string) (*LoadedTable, error) {
+	if r == nil {
+		return nil, fmt.Errorf("No table found")
+	}
+	key := strings.ToLower(name)
+
+	dir := strings.ToLower(from)
+	for {
+		idx := strings.LastIndex(dir, ".")
+		if idx == -1 {
+			break
+		}
+		dir = dir[:idx]
+		if lt, ok := r.tables[dir+"."+key]; ok {
+			return lt, nil
+		}
+	}
+	if lt, ok := r.tables[key]; ok {
+		return lt, nil
+	}
+
+	var matches []*LoadedTable
+	for _, lt := range r.byBase[key[strings.LastIndex(key, ".")+1:]] {
+		if strings.HasSuffix(lt.name, "."+key) {
+			matches = append(matches, lt)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("No table found")
+	case 1:
+		return matches[0], nil
+	}
+	names := make([]string, len(matches))
+	for j, lt := range matches {
+		names[j] = lt.name
+	}
+	sort.Strings(names)
+	return nil, &AmbiguousTableError{Name: name, Matches: names}
+}
+
+// resolve a reference to a group of another table
+// Table.Group, Category.Table.Group or a bare Table which
+// means its Start group. The longest leading part of ref
+// that names a table is used
+func (r *Registry) resolveRef(ref, from string) (*LoadedTable, string, error) {
+	parts := strings.Split(ref, ".")
+	for j := len(parts); j > 0; j-- {
+		group := "Start"
+		if j < len(parts) {
+			group = strings.Join(parts[j:], ".")
+		}
+		lt, err := r.Lookup(strings.Join(parts[:j], "."), from)
+		if err == nil {
+			return lt, group, nil
+		}
+		if _, ok := err.(*AmbiguousTableError); ok {
+			return nil, "", err
+		}
+	}
+	if len(parts) == 1 {
+		return nil, "", fmt.Errorf("no group or table named %s", ref)
+	}
+	return nil, "", fmt.Errorf("no table named %s", parts[0])
+}
+
+// Resolve parses the table named in a reference such as
+// Names.Greek.Start, returning it with the group to roll on
+func (r *Registry) Resolve(ref string) (*Table, string, error) {
+	lt, group, err := r.resolveRef(ref, "")
+	if err != nil {
+		return nil, "", err
+	}
+	t, err := lt.Parse()
+	return t, group, err
+}
+
+// Resolve a reference to a table in the TableRegistry
+func Resolve(ref string) (*Table, string, error) {
+	return TableRegistry.Resolve(ref)
+}
+
+// Parse the table named, see Lookup
+func (r *Registry) Parse(name string) (*Table, error) {
+	loadedTable, err := r.Lookup(name, "")
+	if err != nil {
+		return nil, err
+	}
+	return loadedTable.Parse()
+}
+
+// Most import Variable -- holds all table references where
+// Parse/Lookup can find tables ....
+var TableRegistry *Registry
+
+func LoadAllTables(rootpath string) error {
+	paths, err := FindTables(rootpath)
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("No tables found")
+	}
+	TableRegistry = NewTableList(rootpath, paths)
+	return nil
+}
@@ -0,0 +1,130 @@
+package tables
+
+/*
+ * Test finding tables by category, see registry.go
+ */
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// write a table file with a single Start entry, under root
+func writeTestTable(t *testing.T, root, name, text string) {
+	path := filepath.Join(root, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	content := ":Start\n1," + text + "\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	root := t.TempDir()
+	writeTestTable(t, root, "Names/Greek.tab", "Alexios")
+	writeTestTable(t, root, "Places/Greek.tab", "Athens")
+	writeTestTable(t, root, "Names/Elf.tab", "[Greek]")
+	writeTestTable(t, root, "Orc.tab", "Grok")
+	if err := LoadAllTables(root); err != nil {
+		t.Fatal(err)
+	}
+
+	names := TableRegistry.Names()
+	if len(names) != 4 || names[0] != "names.elf" || names[3] != "places.greek" {
+		t.Logf("wrong table names %v", names)
+		t.Fail()
+	}
+
+	tests := []struct {
+		name     string
+		from     string
+		expected string
+		err      bool
+	}{
+		{name: "Names.Greek", expected: "names.greek"},
+		{name: "places.greek", expected: "places.greek"},
+		{name: "Elf", expected: "names.elf"},
+		{name: "Orc", expected: "orc"},
+		{name: "Greek", err: true},
+		{name: "Greek", from: "names.elf", expected: "names.greek"},
+		{name: "Orc", from: "names.elf", expected: "orc"},
+		{name: "Dwarf", err: true},
+	}
+
+	for tcase, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			lt, err := TableRegistry.Lookup(tt.name, tt.from)
+			if tt.err {
+				if err == nil {
+					t.Logf("Case %d: %s wanted an error, have %s", tcase, tt.name, lt.name)
+					t.Fail()
+				}
+				return
+			}
+			if err != nil || lt.name != tt.expected {
+				t.Logf("Case %d: %s wanted %s, have %v %v", tcase, tt.name, tt.expected, lt, err)
+				t.Fail()
+			}
+		})
+	}
+
+	// a short name shared by two categories lists both
+	_, err := TableRegistry.Lookup("Greek", "")
+	if ambiguous, ok := err.(*AmbiguousTableError); !ok || len(ambiguous.Matches) != 2 {
+		t.Logf("expected an AmbiguousTableError, have %v", err)
+		t.Fail()
+	}
+
+	// a reference from a table is found in its own category first
+	elf, group, err := Resolve("Names.Elf.Start")
+	if err != nil || group != "Start" {
+		t.Fatalf("Names.Elf.Start did not resolve: %v", err)
+	}
+	if res := elf.Roll("Start"); res != "Alexios" {
+		t.Logf("wanted Alexios, have %q", res)
+		t.Fail()
+	}
+}
+
+// every goroutine gets the table parsed by the first,
+// diagnostics are reported once
+func TestParseConcurrent(t *testing.T) {
+	root := t.TempDir()
+	writeTestTable(t, root, "Orc.tab", "Grok\n/Stylesheet x")
+	paths, err := FindTables(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewTableList(root, paths)
+	var mu sync.Mutex
+	var diags []Diagnostic
+	r.diagnose = func(d Diagnostic) {
+		mu.Lock()
+		diags = append(diags, d)
+		mu.Unlock()
+	}
+
+	const PARSERS = 16
+	parsed := make([]*Table, PARSERS)
+	var wg sync.WaitGroup
+	for j := 0; j < PARSERS; j++ {
+		wg.Add(1)
+		go func(j int) {
+			defer wg.Done()
+			parsed[j], _ = r.Parse("Orc")
+		}(j)
+	}
+	wg.Wait()
+	for j := range parsed {
+		if parsed[j] == nil || parsed[j] != parsed[0] {
+			t.Fatalf("parser %d has table %p, parser 0 has %p", j, parsed[j], parsed[0])
+		}
+	}
+	if len(diags) != 1 {
+		t.Logf("wanted the warning once, have %v", diags)
+		t.Fail()
+	}
+}
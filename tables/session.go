@@ -0,0 +1,205 @@
+package tables
+
+/*
+ * A Session holds the state of one generation; the variables,
+ * the entries already used by use once groups, the datasets
+ * and the trace.
+ * Parsed tables are cached by the TableRegistry and shared, so
+ * rolling never changes them, each Session works on its own
+ * copy of every table it rolls on.
+ *
+ * Many goroutines may roll on the same parsed tables at once,
+ * each with its own Session. A Session is used by one goroutine.
+ */
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"rtbl/datasets"
+	"rtbl/rng"
+	"strings"
+)
+
+// EvalError is an error made while rolling, e.g. a reference
+// to a missing group, the generated text holds an --ERROR message
+type EvalError struct {
+	Table string
+	Kind  string // e.g. Rolling Group, Calling Builtin
+	Ref   string // e.g. [Group], UCase(text), %Var%
+	Err   error
+}
+
+func (e *EvalError) Error() string {
+	return fmt.Sprintf("%s: %s %s: %s", e.Table, e.Kind, e.Ref, e.Err)
+}
+
+func (e *EvalError) Unwrap() error {
+	return e.Err
+}
+
+type EvalErrors []*EvalError
+
+func (es EvalErrors) Error() string {
+	var lines []string
+	for _, e := range es {
+		lines = append(lines, e.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+type Session struct {
+	tables map[*Table]*Table  // parsed table to its copy in this session
+	trace  *TraceNode         // current node while tracing, see StartTrace
+	rand   rng.Source         // nil for the rng package source
+	ctx    context.Context    // rolling stops when done, may be nil
+	errs   EvalErrors         // every --ERROR made while rolling
+	loops  int                // the most loops of a While, 0 for MAX_LOOPS
+	stop   bool               // set by Stop, nothing more is rolled in the generation
+	depth  int                // rolls in progress, 0 between generations
+	prompt Prompter           // asked by InputList, nil for the default
+	sets   *datasets.Registry // made by DSCreate, nil until the first
+}
+
+// the most loops of a While, unless the session sets its own
+const MAX_LOOPS = 1000
+
+func NewSession() *Session {
+	return &Session{tables: make(map[*Table]*Table)}
+}
+
+// Seed gives the session its own random source started from seed,
+// so rolls in the session are repeatable and do not use the rng
+// package source shared by every goroutine
+func (s *Session) Seed(seed int64) {
+	s.rand = rand.New(rand.NewSource(seed))
+}
+
+// SetMaxLoops sets the most loops a While may make before it
+// is an error, n <= 0 is MAX_LOOPS
+func (s *Session) SetMaxLoops(n int) {
+	s.loops = n
+}
+
+// SetPrompter sets who InputList asks to choose an option,
+// without one the default option is used
+func (s *Session) SetPrompter(p Prompter) {
+	s.prompt = p
+}
+
+// Stopped is true once Stop ended the generation, until the
+// next generation starts
+func (s *Session) Stopped() bool {
+	return s.stop
+}
+
+// begin a roll, or evaluation, of the table in its session, the
+// first of a generation clears Stop, so Stop ends only the generation
+// it is called in, call the returned func when done
+func (t *Table) begin() func() {
+	if t.session.depth == 0 {
+		t.session.stop = false
+	}
+	t.session.depth++
+	return func() { t.session.depth-- }
+}
+
+// Datasets returns the datasets made in the session
+func (s *Session) Datasets() *datasets.Registry {
+	if s.sets == nil {
+		s.sets = datasets.NewRegistry()
+	}
+	return s.sets
+}
+
+// Rand returns the random source of the session
+func (s *Session) Rand() rng.Source {
+	if s.rand == nil {
+		return rng.Get()
+	}
+	return s.rand
+}
+
+// Table returns the copy of a parsed table used in this session,
+// made the first time the table is used, so variables start
+// with their declared values and no entries have been used
+func (s *Session) Table(t *Table) *Table {
+	if t.session == s {
+		return t
+	}
+	if t.parsed != nil { // a copy from another session
+		t = t.parsed
+	}
+	if c, ok := s.tables[t]; ok {
+		return c
+	}
+	c := *t
+	c.Variables = make(map[string]Variable, len(t.Variables))
+	for name, v := range t.Variables {
+		c.Variables[name] = v
+	}
+	c.Groups = make(map[string]*Group, len(t.Groups))
+	for name, g := range t.Groups {
+		gc := *g
+		gc.used = nil
+		c.Groups[name] = &gc
+	}
+	c.session = s
+	c.parsed = t
+	s.tables[t] = &c
+	return &c
+}
+
+// a table rolled outside of a Session gets a new one,
+// so nothing is carried over from one roll to the next
+func (t *Table) inSession() *Table {
+	if t.session == nil {
+		t = NewSession().Table(t)
+	}
+	t.start()
+	return t
+}
+
+// the random source for rolls on the table
+func (t *Table) random() rng.Source {
+	if t != nil && t.session != nil {
+		return t.session.Rand()
+	}
+	return rng.Get()
+}
+
+// true once the context of the session is done, or
+// Stop was called, nothing more is rolled
+func (t *Table) cancelled() bool {
+	if t.session == nil {
+		return false
+	}
+	return t.session.stop || t.session.ctx != nil && t.session.ctx.Err() != nil
+}
+
+// the most loops of a While in the session of the table
+func (t *Table) maxLoops() int {
+	if t.session == nil || t.session.loops <= 0 {
+		return MAX_LOOPS
+	}
+	return t.session.loops
+}
+
+// record an error made while rolling
+func (t *Table) evalError(kind, ref string, err error) {
+	if t.session == nil {
+		return
+	}
+	t.session.errs = append(t.session.errs, &EvalError{Table: t.Name, Kind: kind, Ref: ref, Err: err})
+}
+
+// Errors returns every error made while rolling in the session,
+// each is also in the generated text as an --ERROR message
+func (s *Session) Errors() EvalErrors {
+	return s.errs
+}
+
+// Session the table copy belongs to, nil for a parsed table
+func (t *Table) Session() *Session {
+	return t.session
+}
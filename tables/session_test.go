@@ -0,0 +1,196 @@
+package tables
+
+/*
+ * Test that generation state is kept in a Session, see session.go
+ */
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+// a table with a use once group and a variable changed by rolling
+func makeSessionTable() *Table {
+	tbl := NewTable("session")
+	tbl.AddVariable("Gold", "10")
+	g := NewGroup(":Start")
+	g.AddItem(1, 1, "[Gear]|Gold+5|%Gold%")
+	tbl.AddGroup(g)
+	g = NewGroup(":!Gear")
+	g.AddItem(1, 1, "rope")
+	g.AddItem(2, 2, "lamp")
+	tbl.AddGroup(g)
+	return tbl
+}
+
+func TestSessionNoLeak(t *testing.T) {
+	tbl := makeSessionTable()
+	// each roll starts with every entry unused and Gold at 10
+	for j := 0; j < 5; j++ {
+		res := tbl.Roll("Start")
+		if res != "rope15" && res != "lamp15" {
+			t.Fatalf("roll %d leaked state from an earlier roll: %q", j, res)
+		}
+	}
+	if v, _ := tbl.GetVariable("Gold"); v != "10" {
+		t.Logf("parsed table variable changed to %s", v)
+		t.Fail()
+	}
+	if tbl.Groups["Gear"].used != nil {
+		t.Log("parsed table group marked entries used")
+		t.Fail()
+	}
+}
+
+func TestSessionShared(t *testing.T) {
+	tbl := makeSessionTable()
+	session := NewSession()
+	a := session.Table(tbl).Roll("Start")
+	b := session.Table(tbl).Roll("Start")
+	c := session.Table(tbl).Roll("Start")
+	if a[:4] == b[:4] || a[4:] != "15" || b[4:] != "20" || c != "25" {
+		t.Logf("session did not carry state between rolls: %q %q %q", a, b, c)
+		t.Fail()
+	}
+	if session.Table(tbl) != session.Table(session.Table(tbl)) {
+		t.Log("copy of a copy is not the sessions copy")
+		t.Fail()
+	}
+	other := NewSession().Table(session.Table(tbl))
+	if res := other.Roll("Start"); res[4:] != "15" {
+		t.Logf("new session started from another sessions state: %q", res)
+		t.Fail()
+	}
+}
+
+// tables rolled by many goroutines, a use once group, variables,
+// dice and a reference to a table parsed on first use
+var hoardFS = fstest.MapFS{
+	"Hoard.tab": {Data: []byte("%Gold%,10\n" +
+		":Start\n1-2,[Gear] and [Gear] of [Names.Greek] {Dice~1d6}|Gold+5| %Gold%\n" +
+		":!Gear\n1,rope\n2,lamp\n3,sword\n4,shield\n")},
+	"Names/Greek.tab": {Data: []byte(":Start\n1-3,[Name]\n;Name\n1,Alexios\n1,Daphne\n1,Nikos\n")},
+}
+
+func hoardRegistry(t *testing.T) *Registry {
+	paths, err := findTablesFS(hoardFS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newRegistry()
+	r.add(hoardFS, ".", paths)
+	return r
+}
+
+// roll on the hoard with a session of its own, seeded
+func rollHoard(r *Registry, seed int64) (string, error) {
+	parsed, err := r.Parse("Hoard")
+	if err != nil {
+		return "", err
+	}
+	s := NewSession()
+	s.Seed(seed)
+	tbl := s.Table(parsed)
+	res := tbl.Roll("Start") + "," + tbl.Roll("Start")
+	if errs := s.Errors(); len(errs) > 0 {
+		return "", errs
+	}
+	return res, nil
+}
+
+// run with -race, goroutines share the parsed tables and
+// each gets the result the seed gives when rolled alone
+func TestSessionConcurrent(t *testing.T) {
+	const ROLLERS = 32
+	const ROLLS = 20
+
+	want := make(map[int64]string)
+	serial := hoardRegistry(t)
+	for seed := int64(0); seed < ROLLS; seed++ {
+		res, err := rollHoard(serial, seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want[seed] = res
+	}
+
+	r := hoardRegistry(t) // nothing parsed, the rollers race to parse
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	errs := make(chan error, ROLLERS*ROLLS)
+	for j := 0; j < ROLLERS; j++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			for seed := int64(0); seed < ROLLS; seed++ {
+				res, err := rollHoard(r, seed)
+				if err != nil {
+					errs <- err
+				} else if res != want[seed] {
+					errs <- fmt.Errorf("seed %d rolled %q, alone it rolls %q", seed, res, want[seed])
+				}
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	parsed, _ := r.Parse("Hoard")
+	if parsed.Groups["Gear"].used != nil || parsed.Variables["Gold"].String() != "10" {
+		t.Log("rolling changed the parsed table")
+		t.Fail()
+	}
+}
+
+// tables rolled outside a Session share the rng package source
+func TestRollConcurrent(t *testing.T) {
+	tbl := makeSessionTable()
+	var wg sync.WaitGroup
+	for j := 0; j < 16; j++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := 0; k < 50; k++ {
+				if res := tbl.Roll("Start"); res != "rope15" && res != "lamp15" {
+					t.Errorf("roll leaked state from another goroutine: %q", res)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// datasets are kept in the session, each generation starts with none
+func TestSessionDatasets(t *testing.T) {
+	tbl := NewTable("party")
+	g := NewGroup(":Start")
+	g.AddItem(1, 1, "{DSCreate~Party,Name,x,HP,0}{DSAdd~Party,Name,Ann,HP,5}{DSAddNR~Party,Name,Bob,HP,7}"+
+		"{DSCount~Party} {DSGet~Party,1,Name} {DSCalc~Party,sum,HP}")
+	tbl.AddGroup(g)
+
+	for j := 0; j < 2; j++ {
+		if res := tbl.Roll("Start"); res != "02 Bob 12.000" {
+			t.Logf("session %d wanted '02 Bob 12.000', have %q", j, res)
+			t.Fail()
+		}
+	}
+	s := NewSession()
+	c := s.Table(tbl)
+	c.Roll("Start")
+	if res := c.Evaluate("{DSCreate~Party,Name,x}"); !strings.Contains(res, "--ERROR") {
+		t.Logf("dataset made twice in a session %q", res)
+		t.Fail()
+	}
+	if res := c.Evaluate("{DSAddNR~Party,Name,Cy}{DSCount~Party}"); res != "3" {
+		t.Logf("dataset not kept in the session %q", res)
+		t.Fail()
+	}
+}
@@ -2,52 +2,141 @@ package tables
 
 import (
 	"fmt"
+	"strconv"
 )
 
 type Table struct {
-	Name      string
-	Path      string
-	Size      int
-	Err       int
-	Header    string            // set by /OutputHeader directive
-	Footer    string            // set by /OutputFooter directive
-	Variables map[string]string // Keyword/value pairs
-	Groups    map[string]*Group
+	Name        string
+	Path        string
+	Size        int
+	Err         int
+	Header      string              // set by /OutputHeader directive
+	Footer      string              // set by /OutputFooter directive
+	Variables   map[string]Variable // Keyword/value pairs
+	Params      []*Param            // declared by @ lines, in order
+	Groups      map[string]*Group
+	Diagnostics Diagnostics // warnings, and errors, found while parsing
+	groupNames  []string    // group names in the order they were added
+	registry    *Registry   // the registry the table was loaded from
+	session     *Session    // nil unless this is a copy made by a Session
+	parsed      *Table      // the parsed table this is a copy of
+	started     bool        // the declared variables of the copy are evaluated
 }
 
 func NewTable(name string) *Table {
 	return &Table{
 		Name:      name,
-		Variables: make(map[string]string),
+		Variables: make(map[string]Variable),
 		Groups:    make(map[string]*Group),
 	}
 }
 
+// AddVariable declares a variable, the value is evaluated
+// once in a session, see GetVariable
 func (t *Table) AddVariable(name string, value string) error {
+	t.setVariable(name, declaredVariable(value))
+	return nil
+}
+
+// AddMacro declares a variable whose value is evaluated
+// every time it is read, %~Name%,value in a table file
+func (t *Table) AddMacro(name string, value string) error {
+	t.setVariable(name, Variable{Kind: VAR_TEXT, Text: value, Macro: true})
+	return nil
+}
+
+// SetVariable sets a variable to a value that is text, or a
+// number, it is never evaluated, e.g. a value from a user
+func (t *Table) SetVariable(name string, value string) {
+	t.setVariable(name, NewVariable(value))
+}
+
+func (t *Table) setVariable(name string, v Variable) {
 	if t.Variables == nil {
-		t.Variables = make(map[string]string)
+		t.Variables = make(map[string]Variable)
 	}
-	/*
-		_, exists := t.Variables[name]
-		if exists {
-			return fmt.Errorf("Variable already exists %s", name)
-		}
-	*/
-	t.Variables[name] = value
-	return nil
+	t.Variables[name] = v
 }
 
+// GetVariable returns the value of a variable as text,
+// the items of a list are separated by LIST_SEPARATOR
+//
+// A declared value, %Name%,value or AddVariable, is evaluated
+// once in a session, when the session first rolls on the table,
+// after its parameters are bound, or when first read if declared
+// later. So %hp%,{Dice~3d6} is the same hit points every time
+// %hp% is read, and in each roll of the session.
+//
+// A macro, %~Name%,value or AddMacro, is evaluated every time
+// it is read, %~Loot%,[Treasure] rolls new treasure each time.
+//
+// Assigned values, |Name=value|, are already evaluated. A table
+// that is not in a Session returns values as declared.
 func (t *Table) GetVariable(name string) (value string, exists bool) {
-	val, exists := t.Variables[name]
-	return val, exists
+	val, exists := t.variable(name)
+	return val.String(), exists
+}
+
+// AssignVariable applies an assignment, |Name op value|, to a
+// variable, the op is one of = + - * / \ > < &
+// integers stay integers, 12+1 is 13, & appends to a list
+func (t *Table) AssignVariable(name, op, newstr string) (string, error) {
+	old, _ := t.variable(name)
+	v, err := old.assign(op, newstr)
+	if err != nil {
+		return "", err
+	}
+	// will add or update variable
+	t.setVariable(name, v)
+	return v.String(), nil
+}
+
+// numbers are formatted without trailing zeros, 2.5 not 2.500000,
+// and to at most 6 decimal places, 0.1+0.2 is 0.3
+func formatNumber(f float64) string {
+	s := stripInsignificantDigits(strconv.FormatFloat(f, 'f', 6, 64))
+	if s == "-0" {
+		return "0"
+	}
+	return s
 }
 
+// add a group to the table, a group with the same name is replaced
+// and reported as an error
 func (t *Table) AddGroup(g *Group) error {
+	_, exists := t.Groups[g.Name]
 	t.Groups[g.Name] = g
+	t.groupNames = append(t.groupNames, g.Name)
 	g.Close()
+	if exists {
+		return fmt.Errorf("table %s already has a group named %s", t.Name, g.Name)
+	}
 	return nil
 }
 
+// the registry other tables are found in, a table made
+// in memory uses the TableRegistry
+func (t *Table) tableRegistry() *Registry {
+	if t.registry != nil {
+		return t.registry
+	}
+	return TableRegistry
+}
+
+// GroupNames returns the name of every group in the order
+// they appear in the table, a replaced group is listed once
+func (t *Table) GroupNames() []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, name := range t.groupNames {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
 func (t *Table) GetGroup(name string) (*Group, error) {
 	g, ok := t.Groups[name]
 	if !ok {
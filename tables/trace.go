@@ -0,0 +1,145 @@
+package tables
+
+/*
+ * Trace records how a generation was built, every group rolled,
+ * builtin called and variable read, as a tree that mirrors the
+ * nesting of the table text.
+ * e.g.
+ *   Sample.Start
+ *     [sample.Start] 1d1=1: You see {AorAn~[Creature]} ...
+ *       {AorAn~Archdaemon} = an Archdaemon
+ *         [sample.Creature] 1d9=5: Archdaemon
+ */
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Kinds of TraceNode
+const (
+	TRACE_GENERATE = "generate"
+	TRACE_ROLL     = "roll"
+	TRACE_BUILTIN  = "builtin"
+	TRACE_VARIABLE = "variable"
+	TRACE_ASSIGN   = "assign"
+)
+
+type TraceNode struct {
+	Kind     string       `json:"kind"`
+	Table    string       `json:"table,omitempty"`
+	Group    string       `json:"group,omitempty"`
+	Die      int          `json:"die,omitempty"`      // rolls are 1D{Die}
+	Dice     string       `json:"dice,omitempty"`     // the roll of a group that declares one, e.g. 2d6
+	Roll     int          `json:"roll,omitempty"`     // the number rolled or selected
+	Modifier string       `json:"modifier,omitempty"` // e.g. +50 from [Group+50]
+	Entry    string       `json:"entry,omitempty"`    // entry text before evaluation
+	Name     string       `json:"name,omitempty"`     // builtin or variable name
+	Args     string       `json:"args,omitempty"`     // evaluated builtin arguments
+	Result   string       `json:"result"`
+	Error    string       `json:"error,omitempty"`
+	Children []*TraceNode `json:"children,omitempty"`
+	parent   *TraceNode
+}
+
+// StartTrace begins recording every roll made in the session, the
+// returned root node collects the tree until StopTrace is called
+func (s *Session) StartTrace(table, group string) *TraceNode {
+	s.trace = &TraceNode{
+		Kind:  TRACE_GENERATE,
+		Table: table,
+		Group: group,
+	}
+	return s.trace
+}
+
+// StopTrace ends recording, the root node is returned
+func (s *Session) StopTrace() *TraceNode {
+	n := s.trace
+	for n != nil && n.parent != nil {
+		n = n.parent
+	}
+	s.trace = nil
+	return n
+}
+
+// RollTrace rolls on the group like Roll and also returns the
+// trace of how the result was built
+func (t *Table) RollTrace(gn string) (string, *TraceNode) {
+	t = t.inSession()
+	t.session.StartTrace(t.Name, gn)
+	gen := t.TryRoll(gn)
+	root := t.session.StopTrace()
+	root.Result = gen
+	return gen, root
+}
+
+// add a child node to the current node and make it current
+// when tracing is off nil is returned and nothing is recorded
+func (t *Table) traceBegin(n *TraceNode) *TraceNode {
+	if t == nil || t.session == nil || t.session.trace == nil {
+		return nil
+	}
+	s := t.session
+	n.parent = s.trace
+	s.trace.Children = append(s.trace.Children, n)
+	s.trace = n
+	return n
+}
+
+// set the result of a node and make its parent current
+func (t *Table) traceEnd(n *TraceNode, result string, err error) {
+	if n == nil {
+		return
+	}
+	n.Result = result
+	if err != nil {
+		n.Error = err.Error()
+	}
+	t.session.trace = n.parent
+}
+
+func (n *TraceNode) label() string {
+	switch n.Kind {
+	case TRACE_GENERATE:
+		return fmt.Sprintf("%s.%s = %q", n.Table, n.Group, n.Result)
+	case TRACE_ROLL:
+		dice := n.Dice
+		if dice == "" {
+			dice = fmt.Sprintf("1d%d", n.Die)
+		}
+		return fmt.Sprintf("[%s.%s%s] %s=%d: %q => %q", n.Table, n.Group, n.Modifier, dice, n.Roll, n.Entry, n.Result)
+	case TRACE_BUILTIN:
+		if n.Error != "" {
+			return fmt.Sprintf("{%s~%s} ERROR %s", n.Name, n.Args, n.Error)
+		}
+		return fmt.Sprintf("{%s~%s} = %q", n.Name, n.Args, n.Result)
+	case TRACE_ASSIGN:
+		if n.Error != "" {
+			return fmt.Sprintf("|%s%s%s| ERROR %s", n.Name, n.Modifier, n.Args, n.Error)
+		}
+		return fmt.Sprintf("|%s%s%s| %%%s%% = %q", n.Name, n.Modifier, n.Args, n.Name, n.Result)
+	case TRACE_VARIABLE:
+		if n.Error != "" {
+			return fmt.Sprintf("%%%s%% ERROR %s", n.Name, n.Error)
+		}
+		return fmt.Sprintf("%%%s%% = %q", n.Name, n.Result)
+	}
+	return n.Kind
+}
+
+func (n *TraceNode) write(b *strings.Builder, depth int) {
+	b.WriteString(strings.Repeat("  ", depth))
+	b.WriteString(n.label())
+	b.WriteString("\n")
+	for _, c := range n.Children {
+		c.write(b, depth+1)
+	}
+}
+
+// String prints the trace as an indented tree
+func (n *TraceNode) String() string {
+	var b strings.Builder
+	n.write(&b, 0)
+	return b.String()
+}
@@ -1,6 +1,8 @@
 package tables
 
 import (
+	"math/rand"
+	"rtbl/rng"
 	"strconv"
 	"testing"
 )
@@ -40,21 +42,6 @@ func TestVariables(t *testing.T) {
 	}
 }
 
-func TestMakeFaces(t *testing.T) {
-	rf := makeFaces(13)
-	if len(rf) != 13 {
-		t.Log(rf)
-		t.Fail()
-	}
-	for j := 0; j < len(rf); j++ {
-		if strconv.Itoa(rf[j].N) != rf[j].Value && rf[j].N != j+1 {
-			t.Log("Faces is not in sequence")
-			t.Log(rf)
-			t.Fail()
-		}
-	}
-}
-
 func TestMakeGroup(t *testing.T) {
 	g := NewGroup("group1")
 	if g == nil {
@@ -68,15 +55,250 @@ func TestMakeGroup(t *testing.T) {
 	}
 
 	g.AddItem(7, 0, "Item")
-	if len(g.table.Items) != 1 {
-		t.Logf("Expected 1 items, %d added instead", len(g.table.Items))
+	if len(g.items) != 1 {
+		t.Logf("Expected 1 items, %d added instead", len(g.items))
 		t.Fail()
 	}
 
 	g.AppendLastItem("more stuff on item")
-	if len(g.table.Items) != 1 {
-		t.Logf("Expected 1 items, %d added instead", len(g.table.Items))
+	if len(g.items) != 1 {
+		t.Logf("Expected 1 items, %d added instead", len(g.items))
+		t.Fail()
+	}
+
+}
+
+func TestGroupRollSeeded(t *testing.T) {
+	g := NewGroup(";Color")
+	for _, c := range []string{"Red", "Green", "Blue", "White", "Black"} {
+		g.AddItem(1, 0, c)
+	}
+	g.Close()
+
+	rng.Seed(99)
+	var first []string
+	for j := 0; j < 20; j++ {
+		first = append(first, g.Roll())
+	}
+	rng.Seed(99)
+	for j := 0; j < 20; j++ {
+		if s := g.Roll(); s != first[j] {
+			t.Logf("Roll %d: same seed returned %s then %s", j, first[j], s)
+			t.Fail()
+		}
+	}
+}
+
+func TestGroupSelect(t *testing.T) {
+	g := NewGroup(":Loot")
+	g.AddItem(1, 2, "copper")
+	g.AddItem(5, 0, "silver") // 3-4 is a gap
+	g.AddItem(6, 9, "gold")
+	g.Close()
+	for n, want := range []string{"", "copper", "copper", "", "", "silver", "gold", "gold", "gold", "gold", ""} {
+		if have := g.Select(n); have != want {
+			t.Logf("Select(%d) wanted %q have %q", n, want, have)
+			t.Fail()
+		}
+	}
+
+	// overlapping, out of order, ranges roll the first entry
+	g = NewGroup(":Mixed")
+	g.AddItem(3, 4, "late")
+	g.AddItem(1, 3, "early")
+	g.AddItem(5, 6, "last")
+	g.Close()
+	for n, want := range []string{"", "early", "early", "late", "late", "last", "last"} {
+		if have := g.Select(n); have != want {
+			t.Logf("Select(%d) wanted %q have %q", n, want, have)
+			t.Fail()
+		}
+	}
+
+	// relative weights are ranges following one another
+	g = NewGroup(";Weight")
+	g.AddItem(3, 0, "common")
+	g.AddItem(1, 0, "rare")
+	g.Close()
+	if g.Max() != 4 || g.Select(3) != "common" || g.Select(4) != "rare" {
+		t.Logf("relative group 1d%d rolls %q on 4", g.Max(), g.Select(4))
+		t.Fail()
+	}
+}
+
+func TestGroupUseOnce(t *testing.T) {
+	g := NewGroup(";!Gear")
+	for j := 1; j <= 50; j++ {
+		g.AddItem(j, 0, strconv.Itoa(j)) // weights of 1 to 50
+	}
+	g.Close()
+	r := rand.New(rand.NewSource(7))
+
+	for pass := 0; pass < 2; pass++ {
+		seen := make(map[string]bool)
+		for j := 0; j < 50; j++ {
+			n, s := g.roll(r)
+			if seen[s] || g.Select(n) != s {
+				t.Fatalf("roll %d of pass %d: %q on %d, used before %v", j, pass, s, n, seen[s])
+			}
+			seen[s] = true
+		}
+		if n, s := g.roll(r); n != 0 || s != "" {
+			t.Fatalf("every entry is used, still rolled %q", s)
+		}
+		g.Reset()
+	}
+
+	// heavier entries are rolled first more often
+	heavy := 0
+	for j := 0; j < 1000; j++ {
+		g.Reset()
+		_, s := g.roll(r)
+		if n, _ := strconv.Atoi(s); n > 25 {
+			heavy++
+		}
+	}
+	if heavy < 700 {
+		t.Logf("entries 26-50 are 75%% of the weight, rolled first %d times in 1000", heavy)
+		t.Fail()
+	}
+}
+
+func TestRemaining(t *testing.T) {
+	r := newRemaining([]int{2, 0, 3, 1})
+	finds := [][3]int{{0, 0, 0}, {1, 0, 1}, {2, 2, 0}, {4, 2, 2}, {5, 3, 0}}
+	for _, f := range finds {
+		if j, off := r.find(f[0]); j != f[1] || off != f[2] {
+			t.Logf("find(%d) wanted %d,%d have %d,%d", f[0], f[1], f[2], j, off)
+			t.Fail()
+		}
+	}
+	r.remove(2, 3)
+	if j, off := r.find(2); r.total != 3 || j != 3 || off != 0 {
+		t.Logf("after remove find(2) is %d,%d of %d", j, off, r.total)
+		t.Fail()
+	}
+}
+
+// a relative group of 100k entries, with weights up to 1000
+func makeLargeGroup(name string) *Group {
+	g := NewGroup(name)
+	r := rand.New(rand.NewSource(1))
+	for j := 0; j < 100000; j++ {
+		g.AddItem(r.Intn(1000)+1, 0, strconv.Itoa(j))
+	}
+	g.Close()
+	return g
+}
+
+func BenchmarkGroupClose100k(b *testing.B) {
+	for j := 0; j < b.N; j++ {
+		makeLargeGroup(";Large")
+	}
+}
+
+func BenchmarkGroupRoll100k(b *testing.B) {
+	g := makeLargeGroup(";Large")
+	r := rand.New(rand.NewSource(1))
+	b.ResetTimer()
+	for j := 0; j < b.N; j++ {
+		g.roll(r)
+	}
+}
+
+// every entry of a use once group, until it is empty
+func BenchmarkGroupUseOnce100k(b *testing.B) {
+	g := makeLargeGroup(";!Large")
+	r := rand.New(rand.NewSource(1))
+	b.ResetTimer()
+	for j := 0; j < b.N; j++ {
+		g.Reset()
+		for k := 0; k < g.Len(); k++ {
+			g.roll(r)
+		}
+	}
+}
+
+func TestGroupDice(t *testing.T) {
+	tests := []struct {
+		spec     string
+		min, max int
+		results  int // that can be rolled
+		ways     int // of rolling the dice
+	}{
+		{"2d6", 2, 12, 11, 36},
+		{"3d6+1", 4, 19, 16, 216},
+		{"d20", 1, 20, 20, 20},
+		{"d66", 11, 66, 36, 36},
+		{"d666", 111, 666, 216, 216},
+	}
+	for _, tt := range tests {
+		d, err := parseGroupDice(tt.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results, ways := 0, 0
+		for _, w := range d.ways() {
+			if w > 0 {
+				results++
+			}
+			ways += w
+		}
+		if d.min() != tt.min || d.max() != tt.max || results != tt.results || ways != tt.ways {
+			t.Logf("%s rolls %d-%d, %d results %d ways", tt.spec, d.min(), d.max(), results, ways)
+			t.Fail()
+		}
+	}
+	for _, spec := range []string{"0d6", "2d1", "20d100", "d66666"} {
+		if _, err := parseGroupDice(spec); err == nil {
+			t.Logf("no error for %s", spec)
+			t.Fail()
+		}
+	}
+
+	// 2d6 makes 7 six times in 36, 2 once
+	g := NewGroup(":Reaction")
+	g.AddItem(2, 2, "two")
+	g.AddItem(3, 6, "low")
+	g.AddItem(7, 7, "seven")
+	g.AddItem(8, 12, "high")
+	if err := g.SetDice("2d6"); err != nil {
+		t.Fatal(err)
+	}
+	g.Close()
+	r := rand.New(rand.NewSource(3))
+	counts := make(map[string]int)
+	for j := 0; j < 3600; j++ {
+		n, s := g.roll(r)
+		if n < 2 || n > 12 || g.Select(n) != s {
+			t.Fatalf("rolled %q on %d", s, n)
+		}
+		counts[s]++
+	}
+	if counts["seven"] < 450 || counts["two"] > 200 {
+		t.Logf("2d6 is not a bell curve %v", counts)
+		t.Fail()
+	}
+	if g.clamp(20) != 12 || g.clamp(0) != 2 {
+		t.Log("modified rolls are not kept within 2d6")
 		t.Fail()
 	}
 
+	// use once rolls only numbers that can be rolled
+	g = NewGroup(":!Events")
+	g.AddItem(11, 16, "ones")
+	g.AddItem(17, 20, "never")
+	g.AddItem(21, 66, "rest")
+	g.SetDice("d66")
+	g.Close()
+	for j := 0; j < 2; j++ {
+		if n, s := g.roll(r); s == "never" || n%10 == 0 || n%10 > 6 {
+			t.Logf("d66 rolled %q on %d", s, n)
+			t.Fail()
+		}
+	}
+	if _, s := g.roll(r); s != "" {
+		t.Logf("d66 entry rolled again %q", s)
+		t.Fail()
+	}
 }
@@ -0,0 +1,310 @@
+package tables
+
+/*
+ * Variables keep the kind of their value, an integer stays an
+ * integer, |Level+1| makes 12 into 13 not 13.000000, and a list
+ * holds items, e.g. the treasure found so far
+ *
+ *   %Gold%,120        VAR_INT
+ *   %Weight%,2.5      VAR_FLOAT
+ *   %Name%,Fred       VAR_TEXT
+ *   {ListAdd~Loot,x}  VAR_LIST, see the List builtins
+ *
+ * A declared value, %Name%,value, is evaluated once in each session,
+ * a macro, %~Name%,value, each time it is read, see Table.GetVariable
+ *
+ * A variable may be shown formatted, %Gold:06% is 000120,
+ * %Gold:,% is 1,200 and %Weight:.2% is 2.50, see formatVariable
+ */
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type VarKind int
+
+const (
+	VAR_TEXT VarKind = iota
+	VAR_INT
+	VAR_FLOAT
+	VAR_LIST
+)
+
+// Variable is the value of a variable of a table
+type Variable struct {
+	Kind VarKind
+	Text string   // the value as given, or formatted for a number made by an assignment
+	Num  float64  // VAR_INT or VAR_FLOAT
+	List []string // VAR_LIST, never changed in place as sessions share it
+	// Text is evaluated each time the variable is read
+	Macro bool
+	// Text is declared, it is evaluated once in a session
+	pending bool
+}
+
+// the separator of list items shown as text
+const LIST_SEPARATOR = ", "
+
+// NewVariable makes a variable of text, a number
+// is a VAR_INT or VAR_FLOAT variable
+func NewVariable(s string) Variable {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil && n >= -1<<53 && n <= 1<<53 {
+		return Variable{Kind: VAR_INT, Text: s, Num: float64(n)}
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+		return Variable{Kind: VAR_FLOAT, Text: s, Num: f}
+	}
+	return Variable{Kind: VAR_TEXT, Text: s}
+}
+
+// a declared variable, text that is only known once evaluated,
+// e.g. {Dice~3d6}, is evaluated when the variable is first used
+func declaredVariable(s string) Variable {
+	if strings.ContainsAny(s, SPECIAL_CHARS) {
+		return Variable{Kind: VAR_TEXT, Text: s, pending: true}
+	}
+	return NewVariable(s)
+}
+
+func intVariable(n float64) Variable {
+	return Variable{Kind: VAR_INT, Text: strconv.FormatFloat(n, 'f', 0, 64), Num: n}
+}
+
+func floatVariable(f float64) Variable {
+	return Variable{Kind: VAR_FLOAT, Text: formatNumber(f), Num: f}
+}
+
+func listVariable(items []string) Variable {
+	return Variable{Kind: VAR_LIST, List: items}
+}
+
+func (v Variable) String() string {
+	if v.Kind == VAR_LIST {
+		return strings.Join(v.List, LIST_SEPARATOR)
+	}
+	return v.Text
+}
+
+func (v Variable) isNumber() bool {
+	return v.Kind == VAR_INT || v.Kind == VAR_FLOAT
+}
+
+// the items of the variable as a list, text is a list of one
+// item, empty text is an empty list
+func (v Variable) Items() []string {
+	if v.Kind == VAR_LIST {
+		return v.List
+	}
+	if v.Text == "" {
+		return nil
+	}
+	return []string{v.Text}
+}
+
+// apply an assignment op to the variable, see Table.AssignVariable,
+// integers make integers, unless divided unevenly
+func (v Variable) assign(op string, s string) (Variable, error) {
+	switch op {
+	case "=":
+		return NewVariable(s), nil
+	case "&":
+		if v.Kind == VAR_LIST {
+			return listVariable(appendItem(v.List, s)), nil
+		}
+		return NewVariable(v.Text + s), nil
+	}
+
+	if v.Kind == VAR_LIST {
+		return v, fmt.Errorf("can not use %s on a list", op)
+	}
+	old := v
+	if old.Text == "" { // a new variable starts at zero
+		old = intVariable(0)
+	}
+	if !old.isNumber() {
+		return v, fmt.Errorf("%s is not a number", old.Text)
+	}
+	arg := NewVariable(strings.TrimSpace(s))
+	if !arg.isNumber() {
+		return v, fmt.Errorf("%s is not a number", s)
+	}
+	a, b := old.Num, arg.Num
+	ints := old.Kind == VAR_INT && arg.Kind == VAR_INT
+	number := func(f float64) Variable {
+		if ints {
+			return intVariable(f)
+		}
+		return floatVariable(f)
+	}
+	switch op {
+	case "+":
+		return number(a + b), nil
+	case "-":
+		return number(a - b), nil
+	case "*":
+		return number(a * b), nil
+	case "/":
+		if b == 0 {
+			return v, fmt.Errorf("division by zero")
+		}
+		if ints && math.Mod(a, b) == 0 {
+			return intVariable(a / b), nil
+		}
+		return floatVariable(a / b), nil
+	case "\\":
+		if b == 0 {
+			return v, fmt.Errorf("division by zero")
+		}
+		return intVariable(math.Trunc(a / b)), nil
+	case ">": // the larger
+		if b > a {
+			return arg, nil
+		}
+		return old, nil
+	case "<": // the smaller
+		if b < a {
+			return arg, nil
+		}
+		return old, nil
+	}
+	return v, fmt.Errorf("Unknown OpCode %s", op)
+}
+
+// a new list, the items shared with other sessions are not changed
+func appendItem(items []string, item string) []string {
+	return append(items[:len(items):len(items)], item)
+}
+
+var (
+	// [0][width][,][.precision], e.g. 03 ,  .2  08,.2
+	lexVarFormat = regexp.MustCompile(`^(0?)(\d*)(,?)(?:\.(\d+))?$`)
+)
+
+// the format of a variable, %Name:format%
+type varFormat struct {
+	zero      bool // pad with zeros rather than spaces
+	width     int
+	thousands bool
+	precision int // -1 for as is
+}
+
+func parseVarFormat(spec string) (varFormat, error) {
+	m := lexVarFormat.FindStringSubmatch(spec)
+	if m == nil || spec == "" {
+		return varFormat{}, fmt.Errorf("unknown format %s, use [0][width][,][.precision]", spec)
+	}
+	f := varFormat{zero: m[1] == "0", thousands: m[3] == ",", precision: -1}
+	f.width, _ = strconv.Atoi(m[2])
+	if m[4] != "" {
+		f.precision, _ = strconv.Atoi(m[4])
+	}
+	return f, nil
+}
+
+// format the text of a variable, numbers are zero padded, have
+// thousands separators and precision, text is only padded
+func formatVariable(s, spec string) (string, error) {
+	f, err := parseVarFormat(spec)
+	if err != nil {
+		return "", err
+	}
+	v := NewVariable(strings.TrimSpace(s))
+	if !v.isNumber() {
+		return pad(s, f.width, " "), nil
+	}
+	sign, digits := "", v.Text
+	if f.precision >= 0 {
+		digits = strconv.FormatFloat(v.Num, 'f', f.precision, 64)
+	}
+	if strings.HasPrefix(digits, "-") || strings.HasPrefix(digits, "+") {
+		sign, digits = digits[:1], digits[1:]
+		if sign == "+" {
+			sign = ""
+		}
+	}
+	if f.thousands {
+		whole, frac := digits, ""
+		if idx := strings.IndexByte(digits, '.'); idx != -1 {
+			whole, frac = digits[:idx], digits[idx:]
+		}
+		var b strings.Builder
+		for j := range whole {
+			if j > 0 && (len(whole)-j)%3 == 0 {
+				b.WriteByte(',')
+			}
+			b.WriteByte(whole[j])
+		}
+		digits = b.String() + frac
+	}
+	if f.zero {
+		return sign + pad(digits, f.width-len(sign), "0"), nil
+	}
+	return pad(sign+digits, f.width, " "), nil
+}
+
+// pad s on the left to width
+func pad(s string, width int, with string) string {
+	if n := width - len(s); n > 0 {
+		return strings.Repeat(with, n) + s
+	}
+	return s
+}
+
+// the value of a variable, a declared value is evaluated, once,
+// and a macro every time, only in a session
+func (t *Table) variable(name string) (Variable, bool) {
+	v, ok := t.Variables[name]
+	if !ok || t.session == nil {
+		return v, ok
+	}
+	if v.Macro {
+		return NewVariable(t.Evaluate(v.Text)), true
+	}
+	if v.pending {
+		// a variable used in its own value is empty
+		t.setVariable(name, Variable{})
+		v = NewVariable(t.Evaluate(v.Text))
+		t.setVariable(name, v)
+	}
+	return v, true
+}
+
+// evaluate every declared variable of the table, once, in order
+// of name so seeded sessions roll the same
+func (t *Table) start() {
+	if t.started {
+		return
+	}
+	t.started = true
+	var names []string
+	for name, v := range t.Variables {
+		if v.pending {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		t.variable(name)
+	}
+}
+
+// the items of a list variable, see the List builtins
+func (t *Table) listItems(name string) ([]string, error) {
+	v, ok := t.variable(name)
+	if !ok {
+		return nil, fmt.Errorf("%%%s%% does not exist", name)
+	}
+	return v.Items(), nil
+}
+
+// AppendVariable adds an item to the end of a list variable,
+// a variable that is not a list becomes one
+func (t *Table) AppendVariable(name, item string) {
+	v, _ := t.variable(name)
+	t.setVariable(name, listVariable(appendItem(v.Items(), item)))
+}
@@ -0,0 +1,244 @@
+package tables
+
+/*
+ * Test typed variables, lists and formatting, see variable.go
+ */
+import (
+	"strings"
+	"testing"
+)
+
+func TestAssignVariable(t *testing.T) {
+	tests := []struct {
+		old      string // "" for no variable
+		op, arg  string
+		expected string
+		kind     VarKind
+	}{
+		{old: "12", op: "+", arg: "1", expected: "13", kind: VAR_INT},
+		{old: "12", op: "-", arg: "20", expected: "-8", kind: VAR_INT},
+		{old: "12", op: "*", arg: "0.5", expected: "6", kind: VAR_FLOAT},
+		{old: "12", op: "/", arg: "4", expected: "3", kind: VAR_INT},
+		{old: "12", op: "/", arg: "8", expected: "1.5", kind: VAR_FLOAT},
+		{old: "12", op: "\\", arg: "5", expected: "2", kind: VAR_INT},
+		{old: "0.1", op: "+", arg: "0.2", expected: "0.3", kind: VAR_FLOAT},
+		{old: "2.50", op: "+", arg: "1", expected: "3.5", kind: VAR_FLOAT},
+		{old: "12", op: ">", arg: "20", expected: "20", kind: VAR_INT},
+		{old: "12", op: ">", arg: "2", expected: "12", kind: VAR_INT},
+		{old: "12", op: "<", arg: "2", expected: "2", kind: VAR_INT},
+		{old: "Sir", op: "&", arg: " Fred", expected: "Sir Fred", kind: VAR_TEXT},
+		{old: "", op: "+", arg: "5", expected: "5", kind: VAR_INT},
+		{old: "1", op: "=", arg: "007", expected: "007", kind: VAR_INT},
+		{old: "1", op: "=", arg: "Fred", expected: "Fred", kind: VAR_TEXT},
+	}
+	for tcase, tt := range tests {
+		tbl := NewTable("assign")
+		if tt.old != "" {
+			tbl.AddVariable("V", tt.old)
+		}
+		res, err := tbl.AssignVariable("V", tt.op, tt.arg)
+		v := tbl.Variables["V"]
+		if err != nil || res != tt.expected || v.String() != tt.expected || v.Kind != tt.kind {
+			t.Logf("Case %d: %s%s%s wanted %s %d, have %q %d %v",
+				tcase, tt.old, tt.op, tt.arg, tt.expected, tt.kind, res, v.Kind, err)
+			t.Fail()
+		}
+	}
+
+	tbl := NewTable("assign")
+	tbl.AddVariable("Name", "Fred")
+	for _, op := range []string{"+", "/"} {
+		if _, err := tbl.AssignVariable("Name", op, "1"); err == nil {
+			t.Logf("Fred%s1 is not an error", op)
+			t.Fail()
+		}
+	}
+	tbl.AddVariable("N", "4")
+	if _, err := tbl.AssignVariable("N", "/", "0"); err == nil {
+		t.Log("division by zero is not an error")
+		t.Fail()
+	}
+	if res := tbl.Evaluate("|N+1|%N% |N*1.5|%N%"); res != "5 7.5" {
+		t.Logf("inline assignment wanted 5 7.5, have %q", res)
+		t.Fail()
+	}
+}
+
+func TestListVariable(t *testing.T) {
+	tbl := NewTable("list")
+	tbl.AddVariable("Loot", "")
+	g := NewGroup(":Start")
+	g.AddItem(1, 1, "{ListAdd~Loot,sword}{ListAdd~Loot,shield}|Loot&helm|"+
+		"{ListCount~Loot} %Loot%; {ListItem~Loot,2}; {ListJoin~Loot, and }; {ListPick~Loot}")
+	tbl.AddGroup(g)
+
+	res := tbl.Roll("Start")
+	expected := "3 sword, shield, helm; shield; sword and shield and helm; "
+	if !strings.HasPrefix(res, expected) {
+		t.Fatalf("wanted %q, have %q", expected, res)
+	}
+	if pick := res[len(expected):]; pick != "sword" && pick != "shield" && pick != "helm" {
+		t.Logf("ListPick picked %q", pick)
+		t.Fail()
+	}
+
+	// a session does not change the list of another
+	s := NewSession()
+	c := s.Table(tbl)
+	c.AppendVariable("Loot", "gem")
+	c.AppendVariable("Loot", "ring")
+	other := NewSession().Table(tbl)
+	other.AppendVariable("Loot", "axe")
+	if v, _ := c.GetVariable("Loot"); v != "gem, ring" {
+		t.Logf("session list is %q", v)
+		t.Fail()
+	}
+	if v, _ := tbl.GetVariable("Loot"); v != "" {
+		t.Logf("parsed list is %q", v)
+		t.Fail()
+	}
+
+	// text is a list of one item
+	tbl.AddVariable("Name", "Fred")
+	if res := tbl.Evaluate("{ListCount~Name} {ListItem~Name,1}"); res != "1 Fred" {
+		t.Logf("text as a list %q", res)
+		t.Fail()
+	}
+	for _, call := range []string{"{ListCount~Nowhere}", "{ListItem~Name,2}", "{ListItem~Name,x}"} {
+		if res := tbl.Evaluate(call); !strings.Contains(res, "--ERROR") {
+			t.Logf("%s is not an error, %q", call, res)
+			t.Fail()
+		}
+	}
+}
+
+func TestFormatVariable(t *testing.T) {
+	tests := []struct {
+		value, format string
+		expected      string
+	}{
+		{"7", "03", "007"},
+		{"-7", "03", "-07"},
+		{"1234567", ",", "1,234,567"},
+		{"-1234.5", ",", "-1,234.5"},
+		{"123", ",", "123"},
+		{"2.5", ".2", "2.50"},
+		{"2.456", ".1", "2.5"},
+		{"1234.5", "010,.2", "001,234.50"},
+		{"42", "5", "   42"},
+		{"Fred", "6", "  Fred"},
+		{"Fred", "03,.2", "Fred"},
+	}
+	for _, tt := range tests {
+		res, err := formatVariable(tt.value, tt.format)
+		if err != nil || res != tt.expected {
+			t.Logf("%s:%s wanted %q, have %q %v", tt.value, tt.format, tt.expected, res, err)
+			t.Fail()
+		}
+	}
+	for _, format := range []string{"x", "3.", ".", "0,3"} {
+		if _, err := formatVariable("1", format); err == nil {
+			t.Logf("format %q is not an error", format)
+			t.Fail()
+		}
+	}
+
+	tbl := NewTable("format")
+	tbl.AddVariable("Gold", "12500")
+	if res := tbl.Evaluate("%Gold:,% gp, %Gold:08% %Gold%"); res != "12,500 gp, 00012500 12500" {
+		t.Logf("formatted variables %q", res)
+		t.Fail()
+	}
+	if res := tbl.Evaluate("%Gold:x%"); !strings.Contains(res, "--ERROR Accessing Variable-- %Gold:x% unknown format x") {
+		t.Logf("bad format %q", res)
+		t.Fail()
+	}
+}
+
+func TestVariableDeclarations(t *testing.T) {
+	content := []string{
+		"@Terrain,Forest,Which terrain,Forest,Swamp",
+		"%hp%,{Dice~3d6}",
+		"%first%,[Gem]",
+		"%~next%,[Gem]",
+		"%where%,the %Terrain%",
+		"%self%,x%self%",
+		"%items%,a,b,c",
+		":Start",
+		"1,%hp% %hp% [Again] %first%%first%",
+		":Again",
+		"1,%hp%",
+		":!Gem",
+		"1,a",
+		"2,b",
+		"3,c",
+	}
+	tbl := parseLines("decl", "decl.tab", content)
+	if tbl.Diagnostics != nil {
+		t.Fatal(tbl.Diagnostics)
+	}
+
+	// the parsed table keeps the values as declared
+	if v, _ := tbl.GetVariable("hp"); v != "{Dice~3d6}" {
+		t.Logf("parsed hp is %q", v)
+		t.Fail()
+	}
+	if v, _ := tbl.GetVariable("items"); v != "a,b,c" {
+		t.Logf("a value with commas is %q", v)
+		t.Fail()
+	}
+	if v := tbl.Variables["next"]; !v.Macro {
+		t.Log("%~next% is not a macro")
+		t.Fail()
+	}
+
+	// a declared value is the same each time it is read
+	seen := make(map[string]bool)
+	for j := 0; j < 50; j++ {
+		res := strings.Fields(tbl.Roll("Start"))
+		if len(res) != 4 || res[0] != res[1] || res[0] != res[2] || res[3][0] != res[3][1] {
+			t.Fatalf("declared values changed when read again %q", res)
+		}
+		seen[res[0]] = true
+	}
+	if len(seen) < 2 {
+		t.Log("hp is the same in every session")
+		t.Fail()
+	}
+
+	// a macro is evaluated each time, drawing each gem once, first
+	// drew its gem when the session started
+	s := NewSession()
+	c := s.Table(tbl)
+	res := c.Evaluate("%next%%next%%first%%next%")
+	if len(res) != 3 || !strings.Contains(res, "a") || !strings.Contains(res, "b") || !strings.Contains(res, "c") {
+		t.Logf("macro wanted each gem, have %q", res)
+		t.Fail()
+	}
+	if res := c.Evaluate("%self%"); res != "x" {
+		t.Logf("a variable in its own value wanted x, have %q", res)
+		t.Fail()
+	}
+
+	// declared values are evaluated after the parameters are bound
+	s = NewSession()
+	c = s.Table(tbl)
+	if err := c.BindArgs([]string{"Terrain=Swamp"}); err != nil {
+		t.Fatal(err)
+	}
+	if res := c.Evaluate("%where%"); res != "the Swamp" {
+		t.Logf("wanted the Swamp, have %q", res)
+		t.Fail()
+	}
+
+	// and in order of name, so a seeded session rolls the same
+	roll := func() string {
+		s := NewSession()
+		s.Seed(42)
+		return s.Table(tbl).Roll("Start")
+	}
+	if first, second := roll(), roll(); first != second {
+		t.Logf("seeded sessions rolled %q then %q", first, second)
+		t.Fail()
+	}
+}
@@ -0,0 +1,51 @@
+package tables
+
+/*
+ * The entries left to roll in a use once group. Each has a weight,
+ * how many numbers roll it, kept in a Fenwick tree so an entry is
+ * found from a roll, and removed, in O(log n) rather than
+ * rerolling until an unused entry comes up.
+ */
+
+type remaining struct {
+	tree  []int // 1 based, tree[k] is the sum of the weights (k - k&-k, k]
+	total int   // sum of the weights left
+}
+
+func newRemaining(weights []int) *remaining {
+	r := &remaining{tree: make([]int, len(weights)+1)}
+	for j, w := range weights {
+		k := j + 1
+		r.tree[k] += w
+		if p := k + k&-k; p < len(r.tree) {
+			r.tree[p] += r.tree[k]
+		}
+		r.total += w
+	}
+	return r
+}
+
+// find the entry holding n, 0 <= n < total, counting only the
+// weights left, along with how far into the entry n is
+func (r *remaining) find(n int) (int, int) {
+	step := 1
+	for step*2 < len(r.tree) {
+		step *= 2
+	}
+	pos := 0
+	for ; step > 0; step /= 2 {
+		if next := pos + step; next < len(r.tree) && r.tree[next] <= n {
+			pos = next
+			n -= r.tree[next]
+		}
+	}
+	return pos, n
+}
+
+// remove the weight w of entry j
+func (r *remaining) remove(j, w int) {
+	for k := j + 1; k < len(r.tree); k += k & -k {
+		r.tree[k] -= w
+	}
+	r.total -= w
+}